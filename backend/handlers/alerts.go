@@ -3,37 +3,61 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Geofences an alert rule applies to.
+const (
+	alertTargetGeofence = "geofence"
+	alertTargetCategory = "category"
+	alertTargetAll      = "all"
 )
 
 type ConfigureAlertRequest struct {
-	GeofenceID string  `json:"geofence_id"`
-	VehicleID  *string `json:"vehicle_id,omitempty"`
-	EventType  string  `json:"event_type"`
+	// Target is "geofence" (the geofence_id), "category" (every geofence of
+	// geofence_category) or "all". It defaults to "category" when
+	// geofence_category is set and to "geofence" otherwise.
+	Target           string  `json:"target,omitempty"`
+	GeofenceID       string  `json:"geofence_id,omitempty"`
+	GeofenceCategory string  `json:"geofence_category,omitempty"`
+	VehicleID        *string `json:"vehicle_id,omitempty"`
+	EventType        string  `json:"event_type"`
+	// DwellSeconds is the time a vehicle must stay inside before a dwell
+	// alert fires. Required for event_type "dwell".
+	DwellSeconds *int `json:"dwell_seconds,omitempty"`
 }
 
 type ConfigureAlertResponse struct {
-	AlertID    string  `json:"alert_id"`
-	GeofenceID string  `json:"geofence_id"`
-	VehicleID  *string `json:"vehicle_id,omitempty"`
-	EventType  string  `json:"event_type"`
-	Status     string  `json:"status"`
-	TimeNs     string  `json:"time_ns"`
+	AlertID          string  `json:"alert_id"`
+	Target           string  `json:"target"`
+	GeofenceID       string  `json:"geofence_id,omitempty"`
+	GeofenceCategory string  `json:"geofence_category,omitempty"`
+	VehicleID        *string `json:"vehicle_id,omitempty"`
+	EventType        string  `json:"event_type"`
+	DwellSeconds     *int    `json:"dwell_seconds,omitempty"`
+	Status           string  `json:"status"`
+	TimeNs           string  `json:"time_ns"`
 }
 
 type AlertWithDetails struct {
-	AlertID       string    `json:"alert_id"`
-	GeofenceID    string    `json:"geofence_id"`
-	GeofenceName  string    `json:"geofence_name"`
-	VehicleID     *string   `json:"vehicle_id,omitempty"`
-	VehicleNumber *string   `json:"vehicle_number,omitempty"`
-	EventType     string    `json:"event_type"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
+	AlertID          string    `json:"alert_id"`
+	Target           string    `json:"target"`
+	GeofenceID       string    `json:"geofence_id,omitempty"`
+	GeofenceName     string    `json:"geofence_name,omitempty"`
+	GeofenceCategory string    `json:"geofence_category,omitempty"`
+	VehicleID        *string   `json:"vehicle_id,omitempty"`
+	VehicleNumber    *string   `json:"vehicle_number,omitempty"`
+	EventType        string    `json:"event_type"`
+	DwellSeconds     *int      `json:"dwell_seconds,omitempty"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
 }
 
 type GetAlertsResponse struct {
@@ -50,26 +74,26 @@ func (h *Handler) ConfigureAlert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate event type
-	validEventTypes := map[string]bool{
-		"entry": true,
-		"exit":  true,
-		"both":  true,
-	}
-	if !validEventTypes[req.EventType] {
-		http.Error(w, "Invalid event_type. Must be one of: entry, exit, both", http.StatusBadRequest)
+	if err := validateAlertRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Generate ID
-	id := "alert_" + uuid.New().String()[:8]
-
-	// Insert alert configuration
-	_, err := h.DB.Exec(`
-		INSERT INTO alerts (id, geofence_id, vehicle_id, event_type, status)
-		VALUES ($1, $2, $3, $4, 'active')
-	`, id, req.GeofenceID, req.VehicleID, req.EventType)
+	refErr, err := checkAlertReferences(h.DB, req)
+	if err != nil {
+		http.Error(w, "Failed to configure alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if refErr != nil {
+		writeError(w, refErr.Status, refErr.Code, refErr.Message, refErr.Details)
+		return
+	}
 
+	id, err := insertAlert(h.DB, req)
+	if isUniqueViolation(err) {
+		writeDuplicateAlert(w, h.DB, req)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to configure alert: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -78,29 +102,160 @@ func (h *Handler) ConfigureAlert(w http.ResponseWriter, r *http.Request) {
 	elapsed := time.Since(start).Nanoseconds()
 
 	response := ConfigureAlertResponse{
-		AlertID:    id,
-		GeofenceID: req.GeofenceID,
-		VehicleID:  req.VehicleID,
-		EventType:  req.EventType,
-		Status:     "active",
-		TimeNs:     fmt.Sprintf("%d", elapsed),
+		AlertID:          id,
+		Target:           req.Target,
+		GeofenceID:       req.GeofenceID,
+		GeofenceCategory: req.GeofenceCategory,
+		VehicleID:        req.VehicleID,
+		EventType:        req.EventType,
+		DwellSeconds:     req.DwellSeconds,
+		Status:           "active",
+		TimeNs:           fmt.Sprintf("%d", elapsed),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// Most rules one batch request may configure.
+const maxBatchAlerts = 1000
+
+// BatchConfigureAlertRequest configures one rule for every combination of
+// the listed geofences and vehicles. Without vehicle_ids the rules match all
+// vehicles.
+type BatchConfigureAlertRequest struct {
+	GeofenceIDs  []string `json:"geofence_ids"`
+	VehicleIDs   []string `json:"vehicle_ids,omitempty"`
+	EventType    string   `json:"event_type"`
+	DwellSeconds *int     `json:"dwell_seconds,omitempty"`
+}
+
+// AlertResult is the outcome of configuring one rule of a batch. On a
+// duplicate, AlertID is the existing alert.
+type AlertResult struct {
+	Index      int     `json:"index"`
+	GeofenceID string  `json:"geofence_id"`
+	VehicleID  *string `json:"vehicle_id,omitempty"`
+	AlertID    string  `json:"alert_id,omitempty"`
+	Created    bool    `json:"created"`
+	Error      string  `json:"error,omitempty"`
+	Message    string  `json:"message,omitempty"`
+}
+
+type BatchConfigureAlertResponse struct {
+	Results  []AlertResult `json:"results"`
+	Created  int           `json:"created"`
+	Rejected int           `json:"rejected"`
+	TimeNs   string        `json:"time_ns"`
+}
+
+// ConfigureAlertsBatch configures the same rule for a list of geofences
+// and/or vehicles. Each rule succeeds or fails on its own.
+func (h *Handler) ConfigureAlertsBatch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var req BatchConfigureAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.GeofenceIDs) == 0 {
+		http.Error(w, "geofence_ids must not be empty", http.StatusBadRequest)
+		return
+	}
+	vehicleIDs := []*string{nil}
+	if len(req.VehicleIDs) > 0 {
+		vehicleIDs = make([]*string, len(req.VehicleIDs))
+		for i := range req.VehicleIDs {
+			vehicleIDs[i] = &req.VehicleIDs[i]
+		}
+	}
+	if len(req.GeofenceIDs)*len(vehicleIDs) > maxBatchAlerts {
+		http.Error(w, fmt.Sprintf("At most %d alerts per batch", maxBatchAlerts), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	template := ConfigureAlertRequest{Target: alertTargetGeofence, EventType: req.EventType, DwellSeconds: req.DwellSeconds}
+	if err := validateAlertRequest(&template); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Each referenced geofence and vehicle is looked up once
+	geofenceErrs := map[string]*referenceError{}
+	vehicleErrs := map[string]*referenceError{}
+	for _, id := range req.GeofenceIDs {
+		if _, seen := geofenceErrs[id]; seen {
+			continue
+		}
+		refErr, err := checkGeofenceReference(h.DB, id)
+		if err != nil {
+			http.Error(w, "Failed to configure alerts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		geofenceErrs[id] = refErr
+	}
+	for _, id := range req.VehicleIDs {
+		if _, seen := vehicleErrs[id]; seen {
+			continue
+		}
+		refErr, err := checkVehicleReference(h.DB, id)
+		if err != nil {
+			http.Error(w, "Failed to configure alerts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		vehicleErrs[id] = refErr
+	}
+
+	response := BatchConfigureAlertResponse{Results: []AlertResult{}}
+	for _, geofenceID := range req.GeofenceIDs {
+		for _, vehicleID := range vehicleIDs {
+			rule := template
+			rule.GeofenceID = geofenceID
+			rule.VehicleID = vehicleID
+
+			result := AlertResult{Index: len(response.Results), GeofenceID: geofenceID, VehicleID: vehicleID}
+			refErr := geofenceErrs[geofenceID]
+			if refErr == nil && vehicleID != nil {
+				refErr = vehicleErrs[*vehicleID]
+			}
+
+			if refErr != nil {
+				result.Error, result.Message = refErr.Code, refErr.Message
+			} else if id, err := insertAlert(h.DB, rule); isUniqueViolation(err) {
+				result.AlertID = findDuplicateAlert(h.DB, rule)
+				result.Error, result.Message = "duplicate_alert", "An identical alert is already configured"
+			} else if err != nil {
+				result.Error, result.Message = "internal_error", "Failed to configure alert: "+err.Error()
+			} else {
+				result.AlertID = id
+				result.Created = true
+			}
+
+			if result.Created {
+				response.Created++
+			} else {
+				response.Rejected++
+			}
+			response.Results = append(response.Results, result)
+		}
+	}
+
+	response.TimeNs = fmt.Sprintf("%d", time.Since(start).Nanoseconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
 	geofenceID := r.URL.Query().Get("geofence_id")
+	category := r.URL.Query().Get("geofence_category")
 	vehicleID := r.URL.Query().Get("vehicle_id")
 
-	query := `
-		SELECT a.id, a.geofence_id, g.name, a.vehicle_id, v.vehicle_number, a.event_type, a.status, a.created_at
-		FROM alerts a
-		JOIN geofences g ON a.geofence_id = g.id
-		LEFT JOIN vehicles v ON a.vehicle_id = v.id
+	query := alertSelect + `
 		WHERE 1=1
 	`
 
@@ -113,6 +268,12 @@ func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
 		argCount++
 	}
 
+	if category != "" {
+		query += fmt.Sprintf(" AND a.geofence_category = $%d", argCount)
+		args = append(args, category)
+		argCount++
+	}
+
 	if vehicleID != "" {
 		query += fmt.Sprintf(" AND a.vehicle_id = $%d", argCount)
 		args = append(args, vehicleID)
@@ -130,22 +291,11 @@ func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
 
 	alerts := []AlertWithDetails{}
 	for rows.Next() {
-		var a AlertWithDetails
-		var vehicleID sql.NullString
-		var vehicleNumber sql.NullString
-
-		err := rows.Scan(&a.AlertID, &a.GeofenceID, &a.GeofenceName, &vehicleID, &vehicleNumber, &a.EventType, &a.Status, &a.CreatedAt)
+		a, err := scanAlert(rows)
 		if err != nil {
 			continue
 		}
 
-		if vehicleID.Valid {
-			a.VehicleID = &vehicleID.String
-		}
-		if vehicleNumber.Valid {
-			a.VehicleNumber = &vehicleNumber.String
-		}
-
 		alerts = append(alerts, a)
 	}
 
@@ -159,3 +309,359 @@ func (h *Handler) GetAlerts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+type DeleteAlertResponse struct {
+	AlertID string `json:"alert_id"`
+	Status  string `json:"status"`
+	TimeNs  string `json:"time_ns"`
+}
+
+type AlertResponse struct {
+	Alert  AlertWithDetails `json:"alert"`
+	TimeNs string           `json:"time_ns"`
+}
+
+// UpdateAlert changes the event type, dwell threshold or vehicle scope of an
+// alert. Fields missing from the body keep their value; "vehicle_id": null
+// widens the alert to all vehicles. The geofences it targets are fixed.
+func (h *Handler) UpdateAlert(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	existing, err := getAlertByID(h.DB, id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "alert_not_found", "Alert not found", map[string]string{"alert_id": id})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch alert", http.StatusInternalServerError)
+		return
+	}
+
+	req := ConfigureAlertRequest{
+		Target:           existing.Target,
+		GeofenceID:       existing.GeofenceID,
+		GeofenceCategory: existing.GeofenceCategory,
+		VehicleID:        existing.VehicleID,
+		EventType:        existing.EventType,
+		DwellSeconds:     existing.DwellSeconds,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Target != existing.Target || req.GeofenceID != existing.GeofenceID || req.GeofenceCategory != existing.GeofenceCategory {
+		http.Error(w, "target, geofence_id and geofence_category cannot be changed; configure a new alert instead", http.StatusBadRequest)
+		return
+	}
+	if err := validateAlertRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	refErr, err := checkAlertReferences(h.DB, req)
+	if err != nil {
+		http.Error(w, "Failed to update alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if refErr != nil {
+		writeError(w, refErr.Status, refErr.Code, refErr.Message, refErr.Details)
+		return
+	}
+
+	_, err = h.DB.Exec(`
+		UPDATE alerts SET vehicle_id = $2, event_type = $3, dwell_seconds = $4 WHERE id = $1
+	`, id, req.VehicleID, req.EventType, req.DwellSeconds)
+	if isUniqueViolation(err) {
+		writeDuplicateAlert(w, h.DB, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeAlert(w, id, start)
+}
+
+// PauseAlert stops an alert from matching events until it is resumed.
+func (h *Handler) PauseAlert(w http.ResponseWriter, r *http.Request) {
+	h.setAlertStatus(w, r, "paused")
+}
+
+func (h *Handler) ResumeAlert(w http.ResponseWriter, r *http.Request) {
+	h.setAlertStatus(w, r, "active")
+}
+
+func (h *Handler) setAlertStatus(w http.ResponseWriter, r *http.Request, status string) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	res, err := h.DB.Exec(`UPDATE alerts SET status = $2 WHERE id = $1`, id, status)
+	if isUniqueViolation(err) {
+		// Resuming a rule that another active rule already covers
+		existing, err := getAlertByID(h.DB, id)
+		if err != nil {
+			http.Error(w, "Failed to fetch alert", http.StatusInternalServerError)
+			return
+		}
+		writeDuplicateAlert(w, h.DB, ConfigureAlertRequest{
+			GeofenceID:       existing.GeofenceID,
+			GeofenceCategory: existing.GeofenceCategory,
+			VehicleID:        existing.VehicleID,
+			EventType:        existing.EventType,
+			DwellSeconds:     existing.DwellSeconds,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "alert_not_found", "Alert not found", map[string]string{"alert_id": id})
+		return
+	}
+
+	h.writeAlert(w, id, start)
+}
+
+func (h *Handler) DeleteAlert(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	res, err := h.DB.Exec(`DELETE FROM alerts WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, http.StatusNotFound, "alert_not_found", "Alert not found", map[string]string{"alert_id": id})
+		return
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := DeleteAlertResponse{
+		AlertID: id,
+		Status:  "deleted",
+		TimeNs:  fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) writeAlert(w http.ResponseWriter, id string, start time.Time) {
+	alert, err := getAlertByID(h.DB, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch alert", http.StatusInternalServerError)
+		return
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := AlertResponse{
+		Alert:  alert,
+		TimeNs: fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+var validEventTypes = map[string]bool{
+	"entry":    true,
+	"exit":     true,
+	"both":     true,
+	"dwell":    true,
+	"speeding": true,
+}
+
+// validateAlertRequest checks the target, event type and dwell threshold,
+// defaulting the target and clearing the threshold for event types that don't
+// use it. Whether the geofence exists is left to checkAlertReferences.
+func validateAlertRequest(req *ConfigureAlertRequest) error {
+	if req.Target == "" {
+		req.Target = alertTargetGeofence
+		if req.GeofenceCategory != "" {
+			req.Target = alertTargetCategory
+		}
+	}
+	switch req.Target {
+	case alertTargetGeofence:
+		if req.GeofenceCategory != "" {
+			return errors.New("geofence_category is only allowed with target category")
+		}
+	case alertTargetCategory:
+		if req.GeofenceID != "" {
+			return errors.New("geofence_id is only allowed with target geofence")
+		}
+		if !validCategories[req.GeofenceCategory] {
+			return errors.New("Invalid geofence_category")
+		}
+	case alertTargetAll:
+		if req.GeofenceID != "" || req.GeofenceCategory != "" {
+			return errors.New("geofence_id and geofence_category are not allowed with target all")
+		}
+	default:
+		return errors.New("Invalid target. Must be one of: geofence, category, all")
+	}
+
+	if !validEventTypes[req.EventType] {
+		return errors.New("Invalid event_type. Must be one of: entry, exit, both, dwell, speeding")
+	}
+
+	// Validate dwell threshold
+	if req.EventType == "dwell" {
+		if req.DwellSeconds == nil || *req.DwellSeconds <= 0 {
+			return errors.New("dwell_seconds must be greater than 0 for dwell alerts")
+		}
+	} else {
+		req.DwellSeconds = nil
+	}
+	return nil
+}
+
+// referenceError describes a rule pointing at a missing or empty geofence or
+// vehicle.
+type referenceError struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]string
+}
+
+// checkAlertReferences verifies that the geofence of a geofence-targeted rule
+// and, when set, the vehicle exist. A nil referenceError means both are valid.
+func checkAlertReferences(db dbExecutor, req ConfigureAlertRequest) (*referenceError, error) {
+	if req.Target == alertTargetGeofence {
+		if refErr, err := checkGeofenceReference(db, req.GeofenceID); refErr != nil || err != nil {
+			return refErr, err
+		}
+	}
+	if req.VehicleID == nil {
+		return nil, nil
+	}
+	return checkVehicleReference(db, *req.VehicleID)
+}
+
+func checkGeofenceReference(db dbExecutor, geofenceID string) (*referenceError, error) {
+	if geofenceID == "" {
+		return &referenceError{http.StatusUnprocessableEntity, "invalid_geofence_id", "geofence_id is required", nil}, nil
+	}
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM geofences WHERE id = $1)`, geofenceID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &referenceError{http.StatusNotFound, "geofence_not_found", "Geofence not found",
+			map[string]string{"geofence_id": geofenceID}}, nil
+	}
+	return nil, nil
+}
+
+func checkVehicleReference(db dbExecutor, vehicleID string) (*referenceError, error) {
+	if vehicleID == "" {
+		return &referenceError{http.StatusUnprocessableEntity, "invalid_vehicle_id",
+			"vehicle_id must not be empty; omit it to match all vehicles", nil}, nil
+	}
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1)`, vehicleID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &referenceError{http.StatusNotFound, "vehicle_not_found", "Vehicle not found",
+			map[string]string{"vehicle_id": vehicleID}}, nil
+	}
+	return nil, nil
+}
+
+func insertAlert(db dbExecutor, req ConfigureAlertRequest) (string, error) {
+	id := "alert_" + uuid.New().String()[:8]
+	_, err := db.Exec(`
+		INSERT INTO alerts (id, geofence_id, geofence_category, vehicle_id, event_type, dwell_seconds, status)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, 'active')
+	`, id, req.GeofenceID, req.GeofenceCategory, req.VehicleID, req.EventType, req.DwellSeconds)
+	return id, err
+}
+
+// findDuplicateAlert returns the ID of the active alert that already covers
+// the same geofences, vehicle scope, event type and dwell threshold.
+func findDuplicateAlert(db dbExecutor, req ConfigureAlertRequest) string {
+	var existingID string
+	db.QueryRow(`
+		SELECT id FROM alerts
+		WHERE geofence_id IS NOT DISTINCT FROM NULLIF($1, '')
+		AND geofence_category IS NOT DISTINCT FROM NULLIF($2, '')
+		AND vehicle_id IS NOT DISTINCT FROM $3
+		AND event_type = $4 AND dwell_seconds IS NOT DISTINCT FROM $5
+		AND status = 'active'
+	`, req.GeofenceID, req.GeofenceCategory, req.VehicleID, req.EventType, req.DwellSeconds).Scan(&existingID)
+	return existingID
+}
+
+func writeDuplicateAlert(w http.ResponseWriter, db dbExecutor, req ConfigureAlertRequest) {
+	writeError(w, http.StatusConflict, "duplicate_alert", "An identical alert is already configured",
+		map[string]string{"alert_id": findDuplicateAlert(db, req)})
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint
+// violation.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+const alertSelect = `
+	SELECT a.id, a.geofence_id, g.name, a.geofence_category, a.vehicle_id, v.vehicle_number,
+		a.event_type, a.dwell_seconds, a.status, a.created_at
+	FROM alerts a
+	LEFT JOIN geofences g ON a.geofence_id = g.id
+	LEFT JOIN vehicles v ON a.vehicle_id = v.id
+`
+
+func getAlertByID(db dbExecutor, id string) (AlertWithDetails, error) {
+	return scanAlert(db.QueryRow(alertSelect+` WHERE a.id = $1`, id))
+}
+
+func scanAlert(row rowScanner) (AlertWithDetails, error) {
+	var a AlertWithDetails
+	var geofenceID, geofenceName, category sql.NullString
+	var vehicleID sql.NullString
+	var vehicleNumber sql.NullString
+	var dwellSeconds sql.NullInt64
+
+	err := row.Scan(&a.AlertID, &geofenceID, &geofenceName, &category, &vehicleID, &vehicleNumber,
+		&a.EventType, &dwellSeconds, &a.Status, &a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+
+	a.GeofenceID, a.GeofenceName, a.GeofenceCategory = geofenceID.String, geofenceName.String, category.String
+	switch {
+	case geofenceID.Valid:
+		a.Target = alertTargetGeofence
+	case category.Valid:
+		a.Target = alertTargetCategory
+	default:
+		a.Target = alertTargetAll
+	}
+
+	if vehicleID.Valid {
+		a.VehicleID = &vehicleID.String
+	}
+	if vehicleNumber.Valid {
+		a.VehicleNumber = &vehicleNumber.String
+	}
+	if dwellSeconds.Valid {
+		n := int(dwellSeconds.Int64)
+		a.DwellSeconds = &n
+	}
+	return a, nil
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LatePolicyStore keeps late points in the location history but does not
+	// evaluate them for entry/exit transitions.
+	LatePolicyStore = "store"
+	// LatePolicyReject refuses late points altogether.
+	LatePolicyReject = "reject"
+)
+
+// Config holds the tunable behaviour of the handlers. It is read from the
+// environment by ConfigFromEnv.
+type Config struct {
+	// LateLocationPolicy decides what happens to a point whose timestamp is
+	// older than the last evaluated point of the same vehicle.
+	LateLocationPolicy string
+
+	// Global entry/exit hysteresis; geofences may override each setting.
+	ExitBufferMeters float64
+	ConfirmFixes     int
+	MinExitDuration  time.Duration
+
+	// DwellCheckInterval is how often dwell alerts are evaluated.
+	DwellCheckInterval time.Duration
+
+	// Locations reporting a worse horizontal accuracy, or implying a faster
+	// jump from the previous location, are flagged instead of evaluated.
+	// Zero disables the check.
+	MaxAccuracyMeters  float64
+	MaxImpliedSpeedKmh float64
+
+	// A trip starts when the vehicle moves more than TripMoveMeters and ends
+	// once it has stayed within that distance for TripStopDuration.
+	TripMoveMeters    float64
+	TripStopDuration  time.Duration
+	TripCheckInterval time.Duration
+
+	// Unknown vehicles whose ID starts with one of these prefixes are
+	// created on their first location. Empty disables auto-registration.
+	AutoRegisterPrefixes []string
+}
+
+func ConfigFromEnv() Config {
+	cfg := Config{
+		LateLocationPolicy: LatePolicyStore,
+		ExitBufferMeters:   envFloat("GEOFENCE_EXIT_BUFFER_METERS", 0),
+		ConfirmFixes:       envInt("GEOFENCE_CONFIRM_FIXES", 1),
+		MinExitDuration:    time.Duration(envInt("GEOFENCE_MIN_EXIT_SECONDS", 0)) * time.Second,
+		DwellCheckInterval: time.Duration(envInt("DWELL_CHECK_SECONDS", 30)) * time.Second,
+		MaxAccuracyMeters:  envFloat("GPS_MAX_ACCURACY_METERS", 100),
+		MaxImpliedSpeedKmh: envFloat("GPS_MAX_SPEED_KMH", 300),
+		TripMoveMeters:     envFloat("TRIP_MOVE_METERS", 100),
+		TripStopDuration:   time.Duration(envInt("TRIP_STOP_MINUTES", 5)) * time.Minute,
+		TripCheckInterval:  time.Duration(envInt("TRIP_CHECK_SECONDS", 60)) * time.Second,
+	}
+
+	for _, prefix := range strings.Split(os.Getenv("AUTO_REGISTER_PREFIXES"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			cfg.AutoRegisterPrefixes = append(cfg.AutoRegisterPrefixes, prefix)
+		}
+	}
+
+	if cfg.DwellCheckInterval <= 0 {
+		cfg.DwellCheckInterval = 30 * time.Second
+	}
+	if cfg.TripStopDuration <= 0 {
+		cfg.TripStopDuration = 5 * time.Minute
+	}
+	if cfg.TripCheckInterval <= 0 {
+		cfg.TripCheckInterval = time.Minute
+	}
+
+	if os.Getenv("LATE_LOCATION_POLICY") == LatePolicyReject {
+		cfg.LateLocationPolicy = LatePolicyReject
+	}
+
+	return cfg
+}
+
+// autoRegisters reports whether an unknown vehicle may be created on its
+// first location.
+func (c Config) autoRegisters(vehicleID string) bool {
+	for _, prefix := range c.AutoRegisterPrefixes {
+		if strings.HasPrefix(vehicleID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return defaultValue
+}
+
+func envInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return defaultValue
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"geofencing-system/spatial"
+
+	"github.com/gorilla/mux"
+)
+
+// Longest range a distance report may cover.
+const maxDistanceReportDays = 366
+
+type DailyDistance struct {
+	Date           string  `json:"date"`
+	DistanceMeters float64 `json:"distance_meters"`
+}
+
+type CategoryDistance struct {
+	Category      string  `json:"category"`
+	InsideMeters  float64 `json:"inside_meters"`
+	OutsideMeters float64 `json:"outside_meters"`
+}
+
+type DistanceReportResponse struct {
+	VehicleID      string             `json:"vehicle_id"`
+	Start          time.Time          `json:"start"`
+	End            time.Time          `json:"end"`
+	Timezone       string             `json:"timezone"`
+	DistanceMeters float64            `json:"distance_meters"`
+	OdometerStart  *float64           `json:"odometer_start,omitempty"`
+	OdometerEnd    *float64           `json:"odometer_end,omitempty"`
+	Days           []DailyDistance    `json:"days"`
+	Categories     []CategoryDistance `json:"categories"`
+	TimeNs         string             `json:"time_ns"`
+}
+
+// GetVehicleDistance reports the geodesic distance a vehicle traveled between
+// start and end (default: the last 7 days), per calendar day in the given
+// timezone (default UTC) and inside versus outside each geofence category.
+// A segment between two points counts as inside a category when its midpoint
+// lies in a geofence of that category. Flagged fixes are ignored.
+func (h *Handler) GetVehicleDistance(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := mux.Vars(r)["id"]
+
+	start, end, err := parseTimeRange(r, 7*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if end.Sub(start) > maxDistanceReportDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("Range must not exceed %d days", maxDistanceReportDays), http.StatusBadRequest)
+		return
+	}
+
+	location := time.UTC
+	if tz := r.URL.Query().Get("timezone"); tz != "" {
+		location, err = time.LoadLocation(tz)
+		if err != nil {
+			http.Error(w, "Unknown timezone", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var exists bool
+	err = h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1)`, vehicleID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	response := DistanceReportResponse{
+		VehicleID:  vehicleID,
+		Start:      start,
+		End:        end,
+		Timezone:   location.String(),
+		Days:       []DailyDistance{},
+		Categories: []CategoryDistance{},
+	}
+
+	// Every category is reported, including those the vehicle never entered
+	categories := map[string]*CategoryDistance{}
+	rows, err := h.DB.Query(`SELECT DISTINCT category FROM geofences`)
+	if err != nil {
+		http.Error(w, "Failed to fetch geofence categories", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err == nil {
+			categories[category] = &CategoryDistance{Category: category}
+		}
+	}
+	rows.Close()
+
+	byDay := map[string]float64{}
+	var prev *TrackPoint
+	err = forEachTrackPoint(h.DB, vehicleID, start, end, func(p TrackPoint) {
+		if p.Odometer != nil {
+			if response.OdometerStart == nil {
+				response.OdometerStart = p.Odometer
+			}
+			response.OdometerEnd = p.Odometer
+		}
+
+		if prev != nil {
+			meters := spatial.DistanceMeters(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+			response.DistanceMeters += meters
+			byDay[p.Timestamp.In(location).Format("2006-01-02")] += meters
+
+			inside := map[string]bool{}
+			for _, e := range h.Index.Search((prev.Latitude+p.Latitude)/2, (prev.Longitude+p.Longitude)/2) {
+				if inside[e.Category] {
+					continue
+				}
+				inside[e.Category] = true
+				if categories[e.Category] == nil {
+					categories[e.Category] = &CategoryDistance{Category: e.Category}
+				}
+				categories[e.Category].InsideMeters += meters
+			}
+		}
+		prev = &p
+	})
+	if err != nil {
+		http.Error(w, "Failed to compute distance", http.StatusInternalServerError)
+		return
+	}
+
+	for _, c := range categories {
+		c.OutsideMeters = response.DistanceMeters - c.InsideMeters
+		response.Categories = append(response.Categories, *c)
+	}
+	sort.Slice(response.Categories, func(a, b int) bool {
+		return response.Categories[a].Category < response.Categories[b].Category
+	})
+
+	for day, meters := range byDay {
+		response.Days = append(response.Days, DailyDistance{Date: day, DistanceMeters: meters})
+	}
+	sort.Slice(response.Days, func(a, b int) bool {
+		return response.Days[a].Date < response.Days[b].Date
+	})
+
+	response.TimeNs = fmt.Sprintf("%d", time.Since(begin).Nanoseconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// storedNeighbour is an unflagged stored location next to a late point.
+type storedNeighbour struct {
+	ID int64
+	trackPoint
+}
+
+// storedNeighbours returns the unflagged stored locations right before and
+// after t in track order, or nil at either end of the track. Points stored
+// later sort after stored points with the same timestamp.
+func storedNeighbours(db dbExecutor, vehicleID string, t time.Time) (prev, next *storedNeighbour, err error) {
+	find := func(query string) (*storedNeighbour, error) {
+		var n storedNeighbour
+		err := db.QueryRow(query, vehicleID, t).Scan(&n.ID, &n.Latitude, &n.Longitude, &n.Timestamp)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return &n, err
+	}
+	prev, err = find(`
+		SELECT id, latitude, longitude, timestamp FROM vehicle_locations
+		WHERE vehicle_id = $1 AND timestamp <= $2 AND NOT flagged
+		ORDER BY timestamp DESC, id DESC LIMIT 1
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	next, err = find(`
+		SELECT id, latitude, longitude, timestamp FROM vehicle_locations
+		WHERE vehicle_id = $1 AND timestamp > $2 AND NOT flagged
+		ORDER BY timestamp, id LIMIT 1
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	return prev, next, nil
+}
+
+// lateDistanceDelta returns how much inserting the late points, in track
+// order, changes the length of the vehicle's unflagged track. It must run
+// before they are stored: each run of late points between the same two
+// stored points replaces the segment between those points.
+func lateDistanceDelta(db dbExecutor, vehicleID string, late []trackPoint) (float64, error) {
+	delta := 0.0
+	var run []trackPoint
+	var runPrev, runNext *storedNeighbour
+
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		for k := 1; k < len(run); k++ {
+			delta += spatial.DistanceMeters(run[k-1].Latitude, run[k-1].Longitude, run[k].Latitude, run[k].Longitude)
+		}
+		first, last := run[0], run[len(run)-1]
+		if runPrev != nil {
+			delta += spatial.DistanceMeters(runPrev.Latitude, runPrev.Longitude, first.Latitude, first.Longitude)
+		}
+		if runNext != nil {
+			delta += spatial.DistanceMeters(last.Latitude, last.Longitude, runNext.Latitude, runNext.Longitude)
+		}
+		if runPrev != nil && runNext != nil {
+			delta -= spatial.DistanceMeters(runPrev.Latitude, runPrev.Longitude, runNext.Latitude, runNext.Longitude)
+		}
+		run = nil
+	}
+
+	sameNeighbour := func(a, b *storedNeighbour) bool {
+		return (a == nil && b == nil) || (a != nil && b != nil && a.ID == b.ID)
+	}
+
+	for _, p := range late {
+		prev, next, err := storedNeighbours(db, vehicleID, p.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+		if len(run) > 0 && !(sameNeighbour(prev, runPrev) && sameNeighbour(next, runNext)) {
+			flush()
+		}
+		if len(run) == 0 {
+			runPrev, runNext = prev, next
+		}
+		run = append(run, p)
+	}
+	flush()
+
+	return delta, nil
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"log"
+	"time"
+)
+
+// dwellCandidate is a vehicle that has been inside a geofence longer than the
+// threshold of a matching dwell alert.
+type dwellCandidate struct {
+	AlertID      string
+	DwellSeconds int
+	VehicleID    string
+	GeofenceID   string
+	GeofenceName string
+	Category     string
+	EnteredAt    time.Time
+}
+
+// RunDwellEvaluator periodically fires dwell alerts. It runs independently of
+// location updates so that a vehicle parked without reporting still triggers
+// the alert.
+func (h *Handler) RunDwellEvaluator(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := h.evaluateDwell(time.Now().UTC()); err != nil {
+			log.Printf("Dwell evaluation failed: %v", err)
+		}
+	}
+}
+
+// evaluateDwell fires every dwell alert whose threshold has passed for the
+// current visit and has not fired for that visit yet. A visit is identified
+// by its entered_at, so each alert fires at most once per visit.
+func (h *Handler) evaluateDwell(now time.Time) error {
+	// Forget notifications of visits that have ended
+	_, err := h.DB.Exec(`
+		DELETE FROM dwell_notifications d
+		WHERE NOT EXISTS (
+			SELECT 1 FROM vehicle_geofence_state s
+			WHERE s.vehicle_id = d.vehicle_id AND s.geofence_id = d.geofence_id
+			AND s.entered_at = d.entered_at AND s.inside
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT a.id, a.dwell_seconds, s.vehicle_id, s.geofence_id, g.name, g.category, s.entered_at
+		FROM vehicle_geofence_state s
+		JOIN geofences g ON s.geofence_id = g.id
+		JOIN alerts a ON (a.geofence_id = s.geofence_id OR a.geofence_category = g.category
+				OR (a.geofence_id IS NULL AND a.geofence_category IS NULL))
+			AND (a.vehicle_id = s.vehicle_id OR a.vehicle_id IS NULL)
+		WHERE a.event_type = 'dwell'
+		AND a.status = 'active'
+		AND s.inside
+		AND s.entered_at + a.dwell_seconds * INTERVAL '1 second' <= $1
+		AND NOT EXISTS (
+			SELECT 1 FROM dwell_notifications d
+			WHERE d.alert_id = a.id AND d.vehicle_id = s.vehicle_id
+			AND d.geofence_id = s.geofence_id AND d.entered_at = s.entered_at
+		)
+	`, now)
+	if err != nil {
+		return err
+	}
+
+	candidates := []dwellCandidate{}
+	for rows.Next() {
+		var c dwellCandidate
+		if err := rows.Scan(&c.AlertID, &c.DwellSeconds, &c.VehicleID, &c.GeofenceID, &c.GeofenceName, &c.Category, &c.EnteredAt); err != nil {
+			rows.Close()
+			return err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		alert, err := h.fireDwellAlert(c)
+		if err != nil {
+			log.Printf("Failed to fire dwell alert %s for vehicle %s: %v", c.AlertID, c.VehicleID, err)
+			continue
+		}
+		if alert != nil {
+			h.broadcastAlerts([][]byte{alert})
+		}
+	}
+
+	return nil
+}
+
+// fireDwellAlert claims the notification and records the violation at the
+// vehicle's latest location. It returns nil when another evaluator already
+// claimed it.
+func (h *Handler) fireDwellAlert(c dwellCandidate) ([]byte, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO dwell_notifications (alert_id, vehicle_id, geofence_id, entered_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, c.AlertID, c.VehicleID, c.GeofenceID, c.EnteredAt)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	var lat, lon float64
+	err = tx.QueryRow(`
+		SELECT latitude, longitude
+		FROM vehicle_locations
+		WHERE vehicle_id = $1 AND NOT flagged
+		ORDER BY timestamp DESC
+		LIMIT 1
+	`, c.VehicleID).Scan(&lat, &lon)
+	if err != nil {
+		return nil, err
+	}
+
+	dwellSeconds := c.DwellSeconds
+	alert, err := recordViolation(tx, violationEvent{
+		VehicleID:    c.VehicleID,
+		GeofenceID:   c.GeofenceID,
+		GeofenceName: c.GeofenceName,
+		Category:     c.Category,
+		EventType:    "dwell",
+		Latitude:     lat,
+		Longitude:    lon,
+		Timestamp:    c.EnteredAt.Add(time.Duration(c.DwellSeconds) * time.Second),
+		DwellSeconds: &dwellSeconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return alert, nil
+}
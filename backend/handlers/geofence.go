@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -10,20 +11,40 @@ import (
 	"geofencing-system/models"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 type CreateGeofenceRequest struct {
-	Name        string       `json:"name"`
-	Description string       `json:"description"`
-	Coordinates [][2]float64 `json:"coordinates"`
-	Category    string       `json:"category"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	models.GeofenceShape
+	Category      string                     `json:"category"`
+	Hysteresis    *models.HysteresisSettings `json:"hysteresis,omitempty"`
+	SpeedLimitKmh *float64                   `json:"speed_limit_kmh,omitempty"`
+	Toll          *models.TollTariff         `json:"toll,omitempty"`
 }
 
 type CreateGeofenceResponse struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Status string `json:"status"`
-	TimeNs string `json:"time_ns"`
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Status   string                `json:"status"`
+	Repaired bool                  `json:"repaired,omitempty"`
+	Geometry *models.GeofenceShape `json:"geometry,omitempty"`
+	TimeNs   string                `json:"time_ns"`
+}
+
+type GeofenceResponse struct {
+	Geofence models.Geofence `json:"geofence"`
+	Repaired bool            `json:"repaired,omitempty"`
+	TimeNs   string          `json:"time_ns"`
+}
+
+type DeleteGeofenceResponse struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	DeletedAlerts     int64  `json:"deleted_alerts"`
+	DeletedViolations int64  `json:"deleted_violations"`
+	TimeNs            string `json:"time_ns"`
 }
 
 type GetGeofencesResponse struct {
@@ -40,76 +61,39 @@ func (h *Handler) CreateGeofence(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate coordinates
-	if len(req.Coordinates) < 4 {
-		http.Error(w, "Minimum 4 coordinate points required", http.StatusBadRequest)
+	if err := validateGeofenceRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Check if polygon is closed
-	if req.Coordinates[0] != req.Coordinates[len(req.Coordinates)-1] {
-		http.Error(w, "First and last coordinates must be identical (closed polygon)", http.StatusBadRequest)
-		return
-	}
-
-	// Validate latitude and longitude ranges
-	for _, coord := range req.Coordinates {
-		if coord[0] < -90 || coord[0] > 90 {
-			http.Error(w, "Latitude must be between -90 and 90", http.StatusBadRequest)
-			return
-		}
-		if coord[1] < -180 || coord[1] > 180 {
-			http.Error(w, "Longitude must be between -180 and 180", http.StatusBadRequest)
-			return
-		}
-	}
-
-	// Validate category
-	validCategories := map[string]bool{
-		"delivery_zone":   true,
-		"restricted_zone": true,
-		"toll_zone":       true,
-		"customer_area":   true,
-	}
-	if !validCategories[req.Category] {
-		http.Error(w, "Invalid category", http.StatusBadRequest)
+	// Validate geometry with PostGIS, optionally repairing it
+	repaired, err := checkGeofenceGeometry(h.DB, &req, r.URL.Query().Get("repair") == "true")
+	if err != nil {
+		writeGeometryError(w, err)
 		return
 	}
 
 	// Generate ID
 	id := "geo_" + uuid.New().String()[:8]
 
-	// Convert coordinates to JSON string
-	coordsJSON, _ := json.Marshal(req.Coordinates)
-
-	// Build WKT polygon string for PostGIS
-	wkt := "POLYGON(("
-	for i, coord := range req.Coordinates {
-		if i > 0 {
-			wkt += ","
-		}
-		wkt += fmt.Sprintf("%f %f", coord[1], coord[0]) // lon lat for WKT
-	}
-	wkt += "))"
-
 	// Insert into database
-	_, err := h.DB.Exec(`
-		INSERT INTO geofences (id, name, description, category, coordinates, geom)
-		VALUES ($1, $2, $3, $4, $5, ST_GeomFromText($6, 4326))
-	`, id, req.Name, req.Description, req.Category, string(coordsJSON), wkt)
-
-	if err != nil {
+	if err := insertGeofence(h.DB, id, req); err != nil {
 		http.Error(w, "Failed to create geofence: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.geofencesChanged()
 
 	elapsed := time.Since(start).Nanoseconds()
 
 	response := CreateGeofenceResponse{
-		ID:     id,
-		Name:   req.Name,
-		Status: "active",
-		TimeNs: fmt.Sprintf("%d", elapsed),
+		ID:       id,
+		Name:     req.Name,
+		Status:   "active",
+		Repaired: repaired,
+		TimeNs:   fmt.Sprintf("%d", elapsed),
+	}
+	if repaired {
+		response.Geometry = &req.GeofenceShape
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -126,14 +110,14 @@ func (h *Handler) GetGeofences(w http.ResponseWriter, r *http.Request) {
 
 	if category != "" {
 		rows, err = h.DB.Query(`
-			SELECT id, name, description, category, coordinates, created_at
+			SELECT `+geofenceColumns+`
 			FROM geofences
 			WHERE category = $1
 			ORDER BY created_at DESC
 		`, category)
 	} else {
 		rows, err = h.DB.Query(`
-			SELECT id, name, description, category, coordinates, created_at
+			SELECT ` + geofenceColumns + `
 			FROM geofences
 			ORDER BY created_at DESC
 		`)
@@ -147,13 +131,10 @@ func (h *Handler) GetGeofences(w http.ResponseWriter, r *http.Request) {
 
 	geofences := []models.Geofence{}
 	for rows.Next() {
-		var g models.Geofence
-		var coordsJSON string
-		err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.Category, &coordsJSON, &g.CreatedAt)
+		g, err := scanGeofence(rows)
 		if err != nil {
 			continue
 		}
-		json.Unmarshal([]byte(coordsJSON), &g.Coordinates)
 		geofences = append(geofences, g)
 	}
 
@@ -167,3 +148,323 @@ func (h *Handler) GetGeofences(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+func (h *Handler) GetGeofence(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	g, err := h.getGeofenceByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch geofence", http.StatusInternalServerError)
+		return
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := GeofenceResponse{
+		Geofence: g,
+		TimeNs:   fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpdateGeofence handles both PUT and PATCH. A PUT must carry the full
+// geofence; a PATCH body is decoded over the stored values so that only the
+// fields present in the request change.
+func (h *Handler) UpdateGeofence(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	existing, err := h.getGeofenceByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch geofence", http.StatusInternalServerError)
+		return
+	}
+
+	var req CreateGeofenceRequest
+	if r.Method == http.MethodPatch {
+		req = CreateGeofenceRequest{
+			Name:          existing.Name,
+			Description:   existing.Description,
+			GeofenceShape: existing.GeofenceShape,
+			Category:      existing.Category,
+			Hysteresis:    existing.Hysteresis,
+			SpeedLimitKmh: existing.SpeedLimitKmh,
+			Toll:          existing.Toll,
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateGeofenceRequest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repaired, err := checkGeofenceGeometry(h.DB, &req, r.URL.Query().Get("repair") == "true")
+	if err != nil {
+		writeGeometryError(w, err)
+		return
+	}
+
+	geom := newGeofenceGeometry(req.GeofenceShape)
+	_, err = h.DB.Exec(`
+		UPDATE geofences
+		SET name = $2, description = $3, category = $4, geofence_type = $5, coordinates = $6,
+			center_latitude = $7, center_longitude = $8, radius_meters = $9,
+			holes = $11, polygons = $12,
+			exit_buffer_meters = $13, confirm_fixes = $14, min_exit_seconds = $15,
+			speed_limit_kmh = $16, toll_tariff = $17,
+			geom = `+geomFromWKTSQL("$10", "$9")+`
+		WHERE id = $1
+	`, append([]interface{}{id, req.Name, req.Description, req.Category, geom.Type, geom.CoordinatesJSON,
+		geom.CenterLat, geom.CenterLon, geom.Radius, geom.WKT, geom.HolesJSON, geom.PolygonsJSON},
+		geofenceAttributeArgs(req)...)...)
+
+	if err != nil {
+		http.Error(w, "Failed to update geofence: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.geofencesChanged()
+
+	g, err := h.getGeofenceByID(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch geofence", http.StatusInternalServerError)
+		return
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := GeofenceResponse{
+		Geofence: g,
+		Repaired: repaired,
+		TimeNs:   fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteGeofence removes a geofence together with the alert configurations
+// and violation history that reference it.
+func (h *Handler) DeleteGeofence(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to delete geofence", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM violations WHERE geofence_id = $1`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete geofence violations", http.StatusInternalServerError)
+		return
+	}
+	deletedViolations, _ := res.RowsAffected()
+
+	res, err = tx.Exec(`DELETE FROM alerts WHERE geofence_id = $1`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete geofence alerts", http.StatusInternalServerError)
+		return
+	}
+	deletedAlerts, _ := res.RowsAffected()
+
+	res, err = tx.Exec(`DELETE FROM geofences WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete geofence", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Geofence not found", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete geofence", http.StatusInternalServerError)
+		return
+	}
+	h.geofencesChanged()
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := DeleteGeofenceResponse{
+		ID:                id,
+		Status:            "deleted",
+		DeletedAlerts:     deletedAlerts,
+		DeletedViolations: deletedViolations,
+		TimeNs:            fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func insertGeofence(db dbExecutor, id string, req CreateGeofenceRequest) error {
+	geom := newGeofenceGeometry(req.GeofenceShape)
+	_, err := db.Exec(`
+		INSERT INTO geofences (id, name, description, category, geofence_type, coordinates,
+			center_latitude, center_longitude, radius_meters, holes, polygons,
+			exit_buffer_meters, confirm_fixes, min_exit_seconds, speed_limit_kmh, toll_tariff, geom)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $11, $12, $13, $14, $15, $16, $17, `+geomFromWKTSQL("$10", "$9")+`)
+	`, append([]interface{}{id, req.Name, req.Description, req.Category, geom.Type, geom.CoordinatesJSON,
+		geom.CenterLat, geom.CenterLon, geom.Radius, geom.WKT, geom.HolesJSON, geom.PolygonsJSON},
+		geofenceAttributeArgs(req)...)...)
+	return err
+}
+
+func (h *Handler) getGeofenceByID(id string) (models.Geofence, error) {
+	row := h.DB.QueryRow(`SELECT `+geofenceColumns+` FROM geofences WHERE id = $1`, id)
+	return scanGeofence(row)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+var validCategories = map[string]bool{
+	"delivery_zone":   true,
+	"restricted_zone": true,
+	"toll_zone":       true,
+	"customer_area":   true,
+}
+
+const geofenceColumns = `id, name, description, category, geofence_type, coordinates,
+	holes, polygons, center_latitude, center_longitude, radius_meters,
+	exit_buffer_meters, confirm_fixes, min_exit_seconds, speed_limit_kmh, toll_tariff, created_at`
+
+func scanGeofence(row rowScanner) (models.Geofence, error) {
+	var g models.Geofence
+	var description sql.NullString
+	var coordsJSON string
+	var holesJSON, polygonsJSON sql.NullString
+	var centerLat, centerLon, radius sql.NullFloat64
+	var exitBuffer sql.NullFloat64
+	var confirmFixes, minExitSeconds sql.NullInt64
+	var speedLimit sql.NullFloat64
+	var tollJSON sql.NullString
+	err := row.Scan(&g.ID, &g.Name, &description, &g.Category, &g.Type, &coordsJSON,
+		&holesJSON, &polygonsJSON, &centerLat, &centerLon, &radius,
+		&exitBuffer, &confirmFixes, &minExitSeconds, &speedLimit, &tollJSON, &g.CreatedAt)
+	if err != nil {
+		return g, err
+	}
+	g.Description = description.String
+	json.Unmarshal([]byte(coordsJSON), &g.Coordinates)
+	if g.Coordinates == nil {
+		g.Coordinates = [][2]float64{}
+	}
+	if holesJSON.Valid {
+		json.Unmarshal([]byte(holesJSON.String), &g.Holes)
+	}
+	if polygonsJSON.Valid {
+		json.Unmarshal([]byte(polygonsJSON.String), &g.Polygons)
+	}
+	if centerLat.Valid && centerLon.Valid {
+		g.Center = &[2]float64{centerLat.Float64, centerLon.Float64}
+	}
+	g.RadiusMeters = radius.Float64
+	if speedLimit.Valid {
+		g.SpeedLimitKmh = &speedLimit.Float64
+	}
+	if tollJSON.Valid {
+		g.Toll = &models.TollTariff{}
+		json.Unmarshal([]byte(tollJSON.String), g.Toll)
+	}
+	if exitBuffer.Valid || confirmFixes.Valid || minExitSeconds.Valid {
+		g.Hysteresis = &models.HysteresisSettings{}
+		if exitBuffer.Valid {
+			g.Hysteresis.ExitBufferMeters = &exitBuffer.Float64
+		}
+		if confirmFixes.Valid {
+			n := int(confirmFixes.Int64)
+			g.Hysteresis.ConfirmFixes = &n
+		}
+		if minExitSeconds.Valid {
+			n := int(minExitSeconds.Int64)
+			g.Hysteresis.MinExitSeconds = &n
+		}
+	}
+	return g, nil
+}
+
+// geofenceAttributeArgs returns the exit_buffer_meters, confirm_fixes,
+// min_exit_seconds, speed_limit_kmh and toll_tariff column values of req.
+func geofenceAttributeArgs(req CreateGeofenceRequest) []interface{} {
+	args := []interface{}{nil, nil, nil, req.SpeedLimitKmh, nil}
+	if hs := req.Hysteresis; hs != nil {
+		args[0], args[1], args[2] = hs.ExitBufferMeters, hs.ConfirmFixes, hs.MinExitSeconds
+	}
+	if req.Toll != nil {
+		tollJSON, _ := json.Marshal(req.Toll)
+		args[4] = string(tollJSON)
+	}
+	return args
+}
+
+func writeGeometryError(w http.ResponseWriter, err error) {
+	if geomErr, ok := err.(*GeometryError); ok {
+		writeError(w, http.StatusUnprocessableEntity, "invalid_geometry", geomErr.Error(), geomErr)
+		return
+	}
+	http.Error(w, "Failed to validate geometry: "+err.Error(), http.StatusInternalServerError)
+}
+
+func validateGeofenceRequest(req CreateGeofenceRequest) error {
+	if err := validateGeofenceShape(req.GeofenceShape); err != nil {
+		return err
+	}
+
+	// Validate category
+	if !validCategories[req.Category] {
+		return errors.New("Invalid category")
+	}
+
+	// Validate speed limit
+	if req.SpeedLimitKmh != nil && (*req.SpeedLimitKmh <= 0 || *req.SpeedLimitKmh > maxSpeedKmh) {
+		return fmt.Errorf("speed_limit_kmh must be greater than 0 and at most %d", maxSpeedKmh)
+	}
+
+	// Validate toll tariff
+	if req.Toll != nil {
+		if req.Category != "toll_zone" {
+			return errors.New("toll is only allowed on toll_zone geofences")
+		}
+		if err := validateTollTariff(*req.Toll); err != nil {
+			return err
+		}
+	}
+
+	// Validate hysteresis overrides
+	if hs := req.Hysteresis; hs != nil {
+		if hs.ExitBufferMeters != nil && (*hs.ExitBufferMeters < 0 || *hs.ExitBufferMeters > maxExitBufferMeters) {
+			return fmt.Errorf("hysteresis.exit_buffer_meters must be between 0 and %d", maxExitBufferMeters)
+		}
+		if hs.ConfirmFixes != nil && (*hs.ConfirmFixes < 1 || *hs.ConfirmFixes > maxConfirmFixes) {
+			return fmt.Errorf("hysteresis.confirm_fixes must be between 1 and %d", maxConfirmFixes)
+		}
+		if hs.MinExitSeconds != nil && (*hs.MinExitSeconds < 0 || *hs.MinExitSeconds > maxMinExitSeconds) {
+			return fmt.Errorf("hysteresis.min_exit_seconds must be between 0 and %d", maxMinExitSeconds)
+		}
+	}
+
+	return nil
+}
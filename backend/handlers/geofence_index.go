@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"geofencing-system/models"
+)
+
+// RefreshGeofenceIndex reloads the in-memory spatial index from the
+// geofences table.
+func (h *Handler) RefreshGeofenceIndex() error {
+	rows, err := h.DB.Query(`SELECT ` + geofenceColumns + ` FROM geofences`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	geofences := []models.Geofence{}
+	for rows.Next() {
+		g, err := scanGeofence(rows)
+		if err != nil {
+			return err
+		}
+		geofences = append(geofences, g)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	h.Index.Load(geofences)
+	return nil
+}
+
+// RunGeofenceIndexRefresher periodically reloads the index so that changes
+// made through other server instances are picked up.
+func (h *Handler) RunGeofenceIndexRefresher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := h.RefreshGeofenceIndex(); err != nil {
+			log.Printf("Failed to refresh geofence index: %v", err)
+		}
+	}
+}
+
+// geofencesChanged is called after every geofence write.
+func (h *Handler) geofencesChanged() {
+	if err := h.RefreshGeofenceIndex(); err != nil {
+		log.Printf("Failed to refresh geofence index: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"geofencing-system/models"
+
+	"github.com/google/uuid"
+)
+
+// GeoJSON positions are [lon, lat]; the rest of the API uses [lat, lon].
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+}
+
+type GeoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type ImportedGeofence struct {
+	FeatureIndex int                   `json:"feature_index"`
+	ID           string                `json:"id"`
+	Name         string                `json:"name"`
+	Repaired     bool                  `json:"repaired,omitempty"`
+	Geometry     *models.GeofenceShape `json:"geometry,omitempty"`
+}
+
+type FeatureError struct {
+	FeatureIndex int    `json:"feature_index"`
+	Name         string `json:"name,omitempty"`
+	Error        string `json:"error"`
+}
+
+type ImportGeofencesResponse struct {
+	Status   string             `json:"status"`
+	Imported []ImportedGeofence `json:"imported"`
+	Errors   []FeatureError     `json:"errors"`
+	TimeNs   string             `json:"time_ns"`
+}
+
+func (h *Handler) ExportGeofencesGeoJSON(w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+
+	query := `
+		SELECT id, name, description, category, geofence_type, radius_meters,
+			ST_AsGeoJSON(CASE WHEN ST_NumGeometries(geom) = 1 THEN ST_GeometryN(geom, 1) ELSE geom END)
+		FROM geofences
+	`
+	args := []interface{}{}
+	if category != "" {
+		query += " WHERE category = $1"
+		args = append(args, category)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch geofences", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	collection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+	for rows.Next() {
+		var id, name, category, geofenceType string
+		var description, geometryJSON sql.NullString
+		var radius sql.NullFloat64
+		if err := rows.Scan(&id, &name, &description, &category, &geofenceType, &radius, &geometryJSON); err != nil {
+			continue
+		}
+		if !geometryJSON.Valid {
+			continue
+		}
+
+		var geometry GeoJSONGeometry
+		if err := json.Unmarshal([]byte(geometryJSON.String), &geometry); err != nil {
+			continue
+		}
+
+		properties := map[string]interface{}{
+			"name":        name,
+			"category":    category,
+			"description": description.String,
+			"type":        geofenceType,
+		}
+		if radius.Valid {
+			properties["radius_meters"] = radius.Float64
+		}
+
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:       "Feature",
+			ID:         id,
+			Properties: properties,
+			Geometry:   &geometry,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
+
+// ImportGeofencesGeoJSON creates one geofence per feature. Every feature is
+// validated first; if any of them fails nothing is stored and the response
+// lists the errors per feature. With repair=true invalid geometries are
+// repaired instead of rejected.
+func (h *Handler) ImportGeofencesGeoJSON(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var collection GeoJSONFeatureCollection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if collection.Type != "FeatureCollection" {
+		http.Error(w, "Body must be a GeoJSON FeatureCollection", http.StatusBadRequest)
+		return
+	}
+
+	repair := r.URL.Query().Get("repair") == "true"
+
+	type pendingGeofence struct {
+		featureIndex int
+		req          CreateGeofenceRequest
+		repaired     bool
+	}
+
+	pending := []pendingGeofence{}
+	featureErrors := []FeatureError{}
+	for i, feature := range collection.Features {
+		req, err := geofenceRequestFromFeature(feature)
+		if err == nil {
+			err = validateGeofenceRequest(req)
+		}
+		var repaired bool
+		if err == nil {
+			repaired, err = checkGeofenceGeometry(h.DB, &req, repair)
+		}
+		if err != nil {
+			featureErrors = append(featureErrors, FeatureError{FeatureIndex: i, Name: req.Name, Error: err.Error()})
+			continue
+		}
+		pending = append(pending, pendingGeofence{featureIndex: i, req: req, repaired: repaired})
+	}
+
+	response := ImportGeofencesResponse{
+		Status:   "failed",
+		Imported: []ImportedGeofence{},
+		Errors:   featureErrors,
+	}
+
+	if len(featureErrors) > 0 {
+		response.TimeNs = fmt.Sprintf("%d", time.Since(start).Nanoseconds())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to import geofences", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, p := range pending {
+		id := "geo_" + uuid.New().String()[:8]
+		if err := insertGeofence(tx, id, p.req); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to import feature %d: %s", p.featureIndex, err.Error()), http.StatusInternalServerError)
+			return
+		}
+		imported := ImportedGeofence{FeatureIndex: p.featureIndex, ID: id, Name: p.req.Name, Repaired: p.repaired}
+		if p.repaired {
+			shape := p.req.GeofenceShape
+			imported.Geometry = &shape
+		}
+		response.Imported = append(response.Imported, imported)
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to import geofences", http.StatusInternalServerError)
+		return
+	}
+	h.geofencesChanged()
+
+	response.Status = "imported"
+	response.TimeNs = fmt.Sprintf("%d", time.Since(start).Nanoseconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// geofenceRequestFromFeature converts a Polygon or MultiPolygon feature into
+// a geofence request.
+func geofenceRequestFromFeature(feature GeoJSONFeature) (CreateGeofenceRequest, error) {
+	name, _ := feature.Properties["name"].(string)
+	description, _ := feature.Properties["description"].(string)
+	category, _ := feature.Properties["category"].(string)
+
+	req := CreateGeofenceRequest{
+		Name:        name,
+		Description: description,
+		Category:    category,
+	}
+
+	shape, err := shapeFromGeoJSON(feature.Geometry)
+	if err != nil {
+		return req, err
+	}
+	req.GeofenceShape = shape
+
+	return req, nil
+}
+
+func shapeFromGeoJSON(geometry *GeoJSONGeometry) (models.GeofenceShape, error) {
+	var shape models.GeofenceShape
+
+	if geometry == nil {
+		return shape, errors.New("feature has no geometry")
+	}
+
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return shape, errors.New("invalid Polygon coordinates")
+		}
+		rings, err := ringsFromGeoJSON(polygon)
+		if err != nil {
+			return shape, err
+		}
+		shape.Type = models.GeofenceTypePolygon
+		shape.Coordinates = rings[0]
+		shape.Holes = rings[1:]
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return shape, errors.New("invalid MultiPolygon coordinates")
+		}
+		shape.Type = models.GeofenceTypeMultiPolygon
+		for _, polygon := range polygons {
+			rings, err := ringsFromGeoJSON(polygon)
+			if err != nil {
+				return shape, err
+			}
+			shape.Polygons = append(shape.Polygons, rings)
+		}
+	default:
+		return shape, fmt.Errorf("unsupported geometry type %q, expected Polygon or MultiPolygon", geometry.Type)
+	}
+
+	return shape, nil
+}
+
+func ringsFromGeoJSON(polygon [][][]float64) ([][][2]float64, error) {
+	if len(polygon) == 0 {
+		return nil, errors.New("polygon has no rings")
+	}
+	rings := make([][][2]float64, 0, len(polygon))
+	for _, positions := range polygon {
+		ring, err := ringFromGeoJSON(positions)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+// ringFromGeoJSON swaps [lon, lat(, alt)] positions into [lat, lon] pairs.
+func ringFromGeoJSON(positions [][]float64) ([][2]float64, error) {
+	ring := make([][2]float64, 0, len(positions))
+	for _, p := range positions {
+		if len(p) < 2 {
+			return nil, errors.New("position must have at least two values")
+		}
+		ring = append(ring, [2]float64{p[1], p[0]})
+	}
+	return ring, nil
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"geofencing-system/models"
+)
+
+// Largest radius accepted for circular geofences.
+const maxCircleRadiusMeters = 100000
+
+// geofenceGeometry holds the column values stored for a geofence shape.
+type geofenceGeometry struct {
+	Type            string
+	CoordinatesJSON string
+	HolesJSON       sql.NullString
+	PolygonsJSON    sql.NullString
+	CenterLat       sql.NullFloat64
+	CenterLon       sql.NullFloat64
+	Radius          sql.NullFloat64
+	WKT             string
+}
+
+func newGeofenceGeometry(shape models.GeofenceShape) geofenceGeometry {
+	switch shape.Type {
+	case models.GeofenceTypeCircle:
+		return geofenceGeometry{
+			Type:            models.GeofenceTypeCircle,
+			CoordinatesJSON: "[]",
+			CenterLat:       sql.NullFloat64{Float64: shape.Center[0], Valid: true},
+			CenterLon:       sql.NullFloat64{Float64: shape.Center[1], Valid: true},
+			Radius:          sql.NullFloat64{Float64: shape.RadiusMeters, Valid: true},
+			WKT:             fmt.Sprintf("POINT(%f %f)", shape.Center[1], shape.Center[0]),
+		}
+	case models.GeofenceTypeMultiPolygon:
+		polygonsJSON, _ := json.Marshal(shape.Polygons)
+		return geofenceGeometry{
+			Type:            models.GeofenceTypeMultiPolygon,
+			CoordinatesJSON: "[]",
+			PolygonsJSON:    sql.NullString{String: string(polygonsJSON), Valid: true},
+			WKT:             buildMultiPolygonWKT(shape.Polygons),
+		}
+	}
+
+	coordsJSON, _ := json.Marshal(shape.Coordinates)
+	g := geofenceGeometry{
+		Type:            models.GeofenceTypePolygon,
+		CoordinatesJSON: string(coordsJSON),
+		WKT:             buildPolygonWKT(append([][][2]float64{shape.Coordinates}, shape.Holes...)),
+	}
+	if len(shape.Holes) > 0 {
+		holesJSON, _ := json.Marshal(shape.Holes)
+		g.HolesJSON = sql.NullString{String: string(holesJSON), Valid: true}
+	}
+	return g
+}
+
+// geomFromWKTSQL returns the SQL expression that builds the geom column from
+// a WKT parameter. When the radius parameter is set the WKT is a center point
+// and the stored geometry is its geodesic buffer, so ST_Contains keeps
+// working for circles. Everything is stored as a MultiPolygon.
+func geomFromWKTSQL(wktParam, radiusParam string) string {
+	return fmt.Sprintf(`ST_Multi(CASE WHEN %[2]s::float8 > 0
+		THEN ST_Buffer(ST_GeomFromText(%[1]s, 4326)::geography, %[2]s::float8)::geometry
+		ELSE ST_GeomFromText(%[1]s, 4326) END)`, wktParam, radiusParam)
+}
+
+func validateGeofenceShape(shape models.GeofenceShape) error {
+	switch shape.Type {
+	case "", models.GeofenceTypePolygon:
+		return validatePolygonRings(append([][][2]float64{shape.Coordinates}, shape.Holes...))
+	case models.GeofenceTypeMultiPolygon:
+		if len(shape.Polygons) == 0 {
+			return errors.New("polygons is required for multipolygon geofences")
+		}
+		for i, polygon := range shape.Polygons {
+			if err := validatePolygonRings(polygon); err != nil {
+				return fmt.Errorf("polygon %d: %s", i, err.Error())
+			}
+		}
+		return nil
+	case models.GeofenceTypeCircle:
+		if shape.Center == nil {
+			return errors.New("center is required for circle geofences")
+		}
+		if err := validateLatLon(shape.Center[0], shape.Center[1]); err != nil {
+			return err
+		}
+		if shape.RadiusMeters <= 0 || shape.RadiusMeters > maxCircleRadiusMeters {
+			return fmt.Errorf("radius_meters must be greater than 0 and at most %d", maxCircleRadiusMeters)
+		}
+		return nil
+	default:
+		return errors.New("Invalid type. Must be one of: polygon, multipolygon, circle")
+	}
+}
+
+// validatePolygonRings checks an outer ring followed by its interior rings.
+func validatePolygonRings(rings [][][2]float64) error {
+	if len(rings) == 0 {
+		return errors.New("Polygon must have an outer ring")
+	}
+	for i, ring := range rings {
+		if err := validateRing(ring); err != nil {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("hole %d: %s", i-1, err.Error())
+		}
+	}
+	return nil
+}
+
+func validateRing(coords [][2]float64) error {
+	// Validate coordinates
+	if len(coords) < 4 {
+		return errors.New("Minimum 4 coordinate points required")
+	}
+
+	// Check if polygon is closed
+	if coords[0] != coords[len(coords)-1] {
+		return errors.New("First and last coordinates must be identical (closed polygon)")
+	}
+
+	// Validate latitude and longitude ranges
+	for _, coord := range coords {
+		if err := validateLatLon(coord[0], coord[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateLatLon(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return errors.New("Latitude must be between -90 and 90")
+	}
+	if lon < -180 || lon > 180 {
+		return errors.New("Longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// buildPolygonWKT converts [lat, lon] rings (outer ring first) into a WKT
+// polygon (lon lat order).
+func buildPolygonWKT(rings [][][2]float64) string {
+	return "POLYGON" + polygonWKTBody(rings)
+}
+
+func buildMultiPolygonWKT(polygons [][][][2]float64) string {
+	wkt := "MULTIPOLYGON("
+	for i, rings := range polygons {
+		if i > 0 {
+			wkt += ","
+		}
+		wkt += polygonWKTBody(rings)
+	}
+	wkt += ")"
+	return wkt
+}
+
+func polygonWKTBody(rings [][][2]float64) string {
+	wkt := "("
+	for i, ring := range rings {
+		if i > 0 {
+			wkt += ","
+		}
+		wkt += "("
+		for j, coord := range ring {
+			if j > 0 {
+				wkt += ","
+			}
+			wkt += fmt.Sprintf("%f %f", coord[1], coord[0])
+		}
+		wkt += ")"
+	}
+	wkt += ")"
+	return wkt
+}
+
+// GeometryError reports why PostGIS considers a geofence geometry invalid.
+type GeometryError struct {
+	Reason   string      `json:"reason"`
+	Location *[2]float64 `json:"location,omitempty"`
+}
+
+func (e *GeometryError) Error() string {
+	if e.Location != nil {
+		return fmt.Sprintf("Invalid geometry: %s at [%f, %f]", e.Reason, e.Location[0], e.Location[1])
+	}
+	return "Invalid geometry: " + e.Reason
+}
+
+// checkGeofenceGeometry validates the polygon shapes of req with
+// ST_IsValidDetail. With repair set, an invalid geometry is replaced by the
+// polygonal part of ST_MakeValid and reported as repaired. An invalid
+// geometry that is not repaired is returned as a *GeometryError.
+func checkGeofenceGeometry(db dbExecutor, req *CreateGeofenceRequest, repair bool) (bool, error) {
+	if req.Type == models.GeofenceTypeCircle {
+		return false, nil
+	}
+
+	wkt := newGeofenceGeometry(req.GeofenceShape).WKT
+
+	var valid bool
+	var reason sql.NullString
+	var lat, lon sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT valid, reason, ST_Y(location), ST_X(location)
+		FROM ST_IsValidDetail(ST_GeomFromText($1, 4326))
+	`, wkt).Scan(&valid, &reason, &lat, &lon)
+	if err != nil {
+		return false, err
+	}
+	if valid {
+		return false, nil
+	}
+
+	if !repair {
+		geomErr := &GeometryError{Reason: reason.String}
+		if lat.Valid && lon.Valid {
+			geomErr.Location = &[2]float64{lat.Float64, lon.Float64}
+		}
+		return false, geomErr
+	}
+
+	var repairedJSON sql.NullString
+	err = db.QueryRow(`
+		SELECT CASE WHEN ST_IsEmpty(g) THEN NULL ELSE ST_AsGeoJSON(g) END
+		FROM (SELECT ST_CollectionExtract(ST_MakeValid(ST_GeomFromText($1, 4326)), 3) AS g) AS repaired
+	`, wkt).Scan(&repairedJSON)
+	if err != nil {
+		return false, err
+	}
+	if !repairedJSON.Valid {
+		return false, &GeometryError{Reason: reason.String + " (could not be repaired)"}
+	}
+
+	var geometry GeoJSONGeometry
+	if err := json.Unmarshal([]byte(repairedJSON.String), &geometry); err != nil {
+		return false, err
+	}
+	shape, err := shapeFromGeoJSON(&geometry)
+	if err != nil {
+		return false, err
+	}
+	if len(shape.Polygons) == 1 {
+		shape = models.GeofenceShape{
+			Type:        models.GeofenceTypePolygon,
+			Coordinates: shape.Polygons[0][0],
+			Holes:       shape.Polygons[0][1:],
+		}
+	}
+	req.GeofenceShape = shape
+	return true, nil
+}
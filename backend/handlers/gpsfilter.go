@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"math"
+
+	"geofencing-system/spatial"
+)
+
+// Reasons a location is flagged as a bad GPS fix. Flagged locations are
+// stored for reference but never evaluated against geofences.
+const (
+	flagLowAccuracy = "low_accuracy"
+	flagTeleport    = "teleport"
+	flagNullIsland  = "null_island"
+)
+
+// Fixes this close to 0,0 in degrees are treated as a receiver without a fix.
+const nullIslandDegrees = 0.0001
+
+// flagLocation returns why p looks like a bad fix, or "" when it looks
+// plausible. prev is the last good position of the vehicle and may be nil.
+func (h *Handler) flagLocation(p UpdateLocationRequest, prev *trackPoint) string {
+	if math.Abs(p.Latitude) < nullIslandDegrees && math.Abs(p.Longitude) < nullIslandDegrees {
+		return flagNullIsland
+	}
+
+	if h.Config.MaxAccuracyMeters > 0 && p.Accuracy != nil && *p.Accuracy > h.Config.MaxAccuracyMeters {
+		return flagLowAccuracy
+	}
+
+	// A jump that would need an impossible speed is a multipath or cold-start fix
+	if h.Config.MaxImpliedSpeedKmh > 0 && prev != nil {
+		meters := spatial.DistanceMeters(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		elapsed := p.Timestamp.Sub(prev.Timestamp).Seconds()
+		if elapsed <= 0 {
+			if meters > 0 {
+				return flagTeleport
+			}
+		} else if meters/elapsed*3.6 > h.Config.MaxImpliedSpeedKmh {
+			return flagTeleport
+		}
+	}
+
+	return ""
+}
@@ -2,17 +2,50 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"geofencing-system/spatial"
 	"geofencing-system/websocket"
 )
 
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx.
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type Handler struct {
-	DB  *sql.DB
-	Hub *websocket.Hub
+	DB     *sql.DB
+	Hub    *websocket.Hub
+	Index  *spatial.Index
+	Config Config
 }
 
 func New(db *sql.DB, hub *websocket.Hub) *Handler {
 	return &Handler{
-		DB:  db,
-		Hub: hub,
+		DB:     db,
+		Hub:    hub,
+		Index:  spatial.NewIndex(),
+		Config: ConfigFromEnv(),
 	}
 }
+
+// ErrorResponse is the JSON body used for errors that carry structured
+// details for the caller.
+type ErrorResponse struct {
+	Error   string      `json:"error"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:   code,
+		Message: message,
+		Details: details,
+	})
+}
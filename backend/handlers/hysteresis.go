@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"time"
+
+	"geofencing-system/spatial"
+)
+
+// Upper bounds for hysteresis settings.
+const (
+	maxExitBufferMeters = 1000
+	maxConfirmFixes     = 20
+	maxMinExitSeconds   = 3600
+)
+
+// hysteresis dampens entry/exit flapping caused by GPS jitter near a
+// geofence boundary.
+type hysteresis struct {
+	// ExitBufferMeters keeps a vehicle inside while it stays within this
+	// distance of the boundary.
+	ExitBufferMeters float64
+	// ConfirmFixes is the number of consecutive fixes needed to confirm an
+	// entry or exit.
+	ConfirmFixes int
+	// MinExitDuration is how long a vehicle must stay outside before an exit
+	// counts.
+	MinExitDuration time.Duration
+}
+
+// hysteresisFor returns the settings for a geofence: the global defaults
+// with its overrides applied. A nil entry gets the defaults.
+func (h *Handler) hysteresisFor(e *spatial.Entry) hysteresis {
+	hs := hysteresis{
+		ExitBufferMeters: h.Config.ExitBufferMeters,
+		ConfirmFixes:     h.Config.ConfirmFixes,
+		MinExitDuration:  h.Config.MinExitDuration,
+	}
+	if e == nil || e.Hysteresis == nil {
+		return hs
+	}
+	if e.Hysteresis.ExitBufferMeters != nil {
+		hs.ExitBufferMeters = *e.Hysteresis.ExitBufferMeters
+	}
+	if e.Hysteresis.ConfirmFixes != nil {
+		hs.ConfirmFixes = *e.Hysteresis.ConfirmFixes
+	}
+	if e.Hysteresis.MinExitSeconds != nil {
+		hs.MinExitDuration = time.Duration(*e.Hysteresis.MinExitSeconds) * time.Second
+	}
+	if hs.ConfirmFixes < 1 {
+		hs.ConfirmFixes = 1
+	}
+	return hs
+}
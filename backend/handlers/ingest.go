@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+)
+
+// Largest number of points accepted by a single batch upload.
+const maxBatchLocations = 5000
+
+// Rows per INSERT when storing locations; PostgreSQL allows at most 65535
+// bind parameters per statement.
+const insertChunkRows = 1000
+
+type BatchLocationRequest struct {
+	Locations []UpdateLocationRequest `json:"locations"`
+}
+
+// LocationResult is the outcome of ingesting one location point.
+type LocationResult struct {
+	Index            int                     `json:"index"`
+	VehicleID        string                  `json:"vehicle_id"`
+	Accepted         bool                    `json:"accepted"`
+	Registered       bool                    `json:"vehicle_registered,omitempty"`
+	Late             bool                    `json:"late,omitempty"`
+	FlagReason       string                  `json:"flag_reason,omitempty"`
+	Error            string                  `json:"error,omitempty"`
+	ErrorCode        string                  `json:"error_code,omitempty"`
+	CurrentGeofences []models.GeofenceStatus `json:"current_geofences,omitempty"`
+}
+
+type BatchLocationResponse struct {
+	Results  []LocationResult `json:"results"`
+	Accepted int              `json:"accepted"`
+	Rejected int              `json:"rejected"`
+	TimeNs   string           `json:"time_ns"`
+}
+
+func (h *Handler) UpdateVehicleLocationsBatch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var req BatchLocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Locations) == 0 {
+		http.Error(w, "locations must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(req.Locations) > maxBatchLocations {
+		http.Error(w, fmt.Sprintf("At most %d locations per batch", maxBatchLocations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]LocationResult, len(req.Locations))
+
+	// Validate each point and group the valid ones by vehicle
+	byVehicle := make(map[string][]int)
+	vehicleOrder := []string{}
+	for i, loc := range req.Locations {
+		results[i] = LocationResult{Index: i, VehicleID: loc.VehicleID}
+		if err := validateLocation(loc); err != nil {
+			results[i].Error = err.Error()
+			if err == errMissingVehicleID {
+				results[i].ErrorCode = "invalid_vehicle_id"
+			}
+			continue
+		}
+		normalizeLocation(&req.Locations[i])
+		if _, seen := byVehicle[loc.VehicleID]; !seen {
+			vehicleOrder = append(vehicleOrder, loc.VehicleID)
+		}
+		byVehicle[loc.VehicleID] = append(byVehicle[loc.VehicleID], i)
+	}
+
+	for _, vehicleID := range vehicleOrder {
+		indexes := byVehicle[vehicleID]
+		points := make([]UpdateLocationRequest, len(indexes))
+		for j, i := range indexes {
+			points[j] = req.Locations[i]
+		}
+
+		vehicleResults, alerts, err := h.ingestVehicleLocations(vehicleID, points)
+		if err == errVehicleNotFound {
+			for _, i := range indexes {
+				results[i].Error = "Vehicle not found"
+				results[i].ErrorCode = "vehicle_not_found"
+			}
+			continue
+		}
+		if err == errVehicleNumberConflict {
+			for _, i := range indexes {
+				results[i].Error = "Cannot auto-register vehicle: " + err.Error()
+				results[i].ErrorCode = "vehicle_number_conflict"
+			}
+			continue
+		}
+		if err != nil {
+			for _, i := range indexes {
+				results[i].Error = "Failed to store location: " + err.Error()
+			}
+			continue
+		}
+		h.broadcastAlerts(alerts)
+
+		for j, i := range indexes {
+			vehicleResults[j].Index = i
+			results[i] = vehicleResults[j]
+		}
+	}
+
+	response := BatchLocationResponse{Results: results}
+	for _, res := range results {
+		if res.Accepted {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+	response.TimeNs = fmt.Sprintf("%d", time.Since(start).Nanoseconds())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+var (
+	errMissingVehicleID = errors.New("vehicle_id is required")
+	errVehicleNotFound  = errors.New("vehicle not found")
+	// errVehicleNumberConflict is returned when an unknown vehicle cannot be
+	// auto-registered because its ID is already another vehicle's number.
+	errVehicleNumberConflict = errors.New("vehicle_number already in use")
+)
+
+func validateLocation(req UpdateLocationRequest) error {
+	if req.VehicleID == "" {
+		return errMissingVehicleID
+	}
+
+	// Validate latitude and longitude
+	if req.Latitude < -90 || req.Latitude > 90 {
+		return errors.New("Latitude must be between -90 and 90")
+	}
+	if req.Longitude < -180 || req.Longitude > 180 {
+		return errors.New("Longitude must be between -180 and 180")
+	}
+	return validateTelemetry(req.Telemetry)
+}
+
+// normalizeLocation fills in defaults for optional fields and converts the
+// timestamp to UTC. The TIMESTAMP columns drop the offset, so a timestamp
+// kept in the device's zone would be stored as wall-clock time and read back
+// as UTC.
+func normalizeLocation(req *UpdateLocationRequest) {
+	if req.Timestamp.IsZero() {
+		req.Timestamp = time.Now()
+	}
+	req.Timestamp = req.Timestamp.UTC()
+}
+
+// ingestVehicleLocations stores points of a single vehicle with one bulk
+// insert and runs entry/exit detection over them in timestamp order, all in
+// one transaction. The results are index-aligned with points; the alerts are
+// ready to broadcast because the transaction has committed.
+//
+// A point older than the vehicle's last evaluated point is late. Under
+// LatePolicyStore it is stored but never changes geofence memberships, so
+// reordered uploads cannot produce phantom entry/exit events; under
+// LatePolicyReject it is refused.
+func (h *Handler) ingestVehicleLocations(vehicleID string, points []UpdateLocationRequest) ([]LocationResult, [][]byte, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize updates for the same vehicle so membership diffs don't race
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, vehicleID); err != nil {
+		return nil, nil, err
+	}
+
+	// Points older than the last evaluated point of the vehicle are late
+	var lastLocationAt sql.NullTime
+	var lastLat, lastLon sql.NullFloat64
+	err = tx.QueryRow(`
+		SELECT last_location_at, last_latitude, last_longitude FROM vehicles WHERE id = $1
+	`, vehicleID).Scan(&lastLocationAt, &lastLat, &lastLon)
+	registered := false
+	if err == sql.ErrNoRows {
+		if !h.Config.autoRegisters(vehicleID) {
+			return nil, nil, errVehicleNotFound
+		}
+		if err := registerVehicle(tx, vehicleID); err != nil {
+			return nil, nil, err
+		}
+		registered = true
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var prev *trackPoint
+	if lastLocationAt.Valid && lastLat.Valid && lastLon.Valid {
+		prev = &trackPoint{Latitude: lastLat.Float64, Longitude: lastLon.Float64, Timestamp: lastLocationAt.Time}
+	}
+
+	results, stored := h.planLocations(vehicleID, points, lastLocationAt, prev)
+	for i := range results {
+		results[i].Registered = registered
+	}
+	if len(stored) == 0 {
+		return results, nil, nil
+	}
+
+	// A late point lands between stored points; adjust the running distance
+	// by the segments it replaces so it keeps agreeing with the reports
+	late := []trackPoint{}
+	for _, i := range stored {
+		if results[i].Late && results[i].FlagReason == "" {
+			late = append(late, trackPoint{Latitude: points[i].Latitude, Longitude: points[i].Longitude, Timestamp: points[i].Timestamp})
+		}
+	}
+	lateDistance, err := lateDistanceDelta(tx, vehicleID, late)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Insert location updates, in chunks to stay under the bind parameter limit
+	for first := 0; first < len(stored); first += insertChunkRows {
+		last := first + insertChunkRows
+		if last > len(stored) {
+			last = len(stored)
+		}
+		if err := insertLocations(tx, vehicleID, points, results, stored[first:last]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Get current geofences containing the vehicle
+	for _, i := range stored {
+		if results[i].FlagReason == "" {
+			results[i].CurrentGeofences = h.getGeofencesContainingPoint(points[i].Latitude, points[i].Longitude)
+		}
+	}
+
+	alerts := [][]byte{}
+	distance := 0.0
+	evaluated := evaluatedLocations(stored, results)
+	for _, i := range evaluated {
+		p := points[i]
+
+		// Detect entry/exit events against the stored memberships
+		pointAlerts, err := h.detectAndHandleEvents(tx, vehicleID, p.Latitude, p.Longitude, p.Timestamp, results[i].CurrentGeofences)
+		if err != nil {
+			return nil, nil, err
+		}
+		alerts = append(alerts, pointAlerts...)
+
+		// Check speed limits of the zones the vehicle is in
+		if speed, source, ok := effectiveSpeed(p, prev); ok {
+			speedAlerts, err := h.detectSpeeding(tx, vehicleID, p, speed, source)
+			if err != nil {
+				return nil, nil, err
+			}
+			alerts = append(alerts, speedAlerts...)
+		}
+
+		if prev != nil {
+			distance += spatial.DistanceMeters(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		}
+		prev = &trackPoint{Latitude: p.Latitude, Longitude: p.Longitude, Timestamp: p.Timestamp}
+	}
+
+	if len(evaluated) > 0 {
+		_, err = tx.Exec(`
+			UPDATE vehicles
+			SET last_location_at = $2, last_latitude = $3, last_longitude = $4,
+				total_distance_meters = total_distance_meters + $5
+			WHERE id = $1
+		`, vehicleID, prev.Timestamp, prev.Latitude, prev.Longitude, distance)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if lateDistance != 0 {
+		_, err = tx.Exec(`
+			UPDATE vehicles SET total_distance_meters = total_distance_meters + $2 WHERE id = $1
+		`, vehicleID, lateDistance)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return results, alerts, nil
+}
+
+// planLocations classifies the incoming points of one vehicle against its
+// last evaluated location. It returns the index-aligned results and the
+// indexes of the points to store, in event-time order.
+//
+// A point older than lastAt is late: refused under LatePolicyReject, stored
+// as history only under LatePolicyStore. Stored points are flagged against
+// the last good position, starting from prev.
+func (h *Handler) planLocations(vehicleID string, points []UpdateLocationRequest, lastAt sql.NullTime, prev *trackPoint) ([]LocationResult, []int) {
+	results := make([]LocationResult, len(points))
+	stored := []int{}
+	for i, p := range points {
+		results[i] = LocationResult{Index: i, VehicleID: vehicleID}
+		late := lastAt.Valid && p.Timestamp.Before(lastAt.Time)
+		if late && h.Config.LateLocationPolicy == LatePolicyReject {
+			results[i].Error = fmt.Sprintf("Location at %s is older than the last accepted location at %s",
+				p.Timestamp.Format(time.RFC3339), lastAt.Time.Format(time.RFC3339))
+			continue
+		}
+		results[i].Accepted = true
+		results[i].Late = late
+		stored = append(stored, i)
+	}
+
+	sort.SliceStable(stored, func(a, b int) bool {
+		return points[stored[a]].Timestamp.Before(points[stored[b]].Timestamp)
+	})
+
+	// Flag bad fixes against the last good position before anything is stored
+	lastGood := prev
+	for _, i := range stored {
+		p := points[i]
+		if results[i].Late {
+			results[i].FlagReason = h.flagLocation(p, nil)
+			continue
+		}
+		results[i].FlagReason = h.flagLocation(p, lastGood)
+		if results[i].FlagReason == "" {
+			lastGood = &trackPoint{Latitude: p.Latitude, Longitude: p.Longitude, Timestamp: p.Timestamp}
+		}
+	}
+
+	return results, stored
+}
+
+// evaluatedLocations returns the stored points that go through entry/exit
+// detection, keeping their event-time order. Late and flagged points are
+// history only and never change geofence memberships.
+func evaluatedLocations(stored []int, results []LocationResult) []int {
+	evaluated := []int{}
+	for _, i := range stored {
+		if !results[i].Late && results[i].FlagReason == "" {
+			evaluated = append(evaluated, i)
+		}
+	}
+	return evaluated
+}
+
+// insertLocations stores points[i] for every i in indexes with one
+// multi-row INSERT, flagged as recorded in results[i].
+func insertLocations(db dbExecutor, vehicleID string, points []UpdateLocationRequest, results []LocationResult, indexes []int) error {
+	const argsPerRow = 6 + telemetryColumnCount
+	values := make([]string, 0, len(indexes))
+	args := make([]interface{}, 0, len(indexes)*argsPerRow)
+	for j, i := range indexes {
+		p := points[i]
+		n := j * argsPerRow
+		placeholders := make([]string, telemetryColumnCount)
+		for k := range placeholders {
+			placeholders[k] = fmt.Sprintf("$%d", n+7+k)
+		}
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, ST_SetSRID(ST_MakePoint($%d, $%d), 4326), $%d, $%d, NULLIF($%d, ''), %s)",
+			n+1, n+2, n+3, n+3, n+2, n+4, n+5, n+6, strings.Join(placeholders, ", ")))
+		flagReason := results[i].FlagReason
+		args = append(args, vehicleID, p.Latitude, p.Longitude, p.Timestamp, flagReason != "", flagReason)
+		args = append(args, telemetryArgs(p.Telemetry)...)
+	}
+	_, err := db.Exec(`
+		INSERT INTO vehicle_locations (vehicle_id, latitude, longitude, geom, timestamp, flagged, flag_reason, `+telemetryColumns+`)
+		VALUES `+strings.Join(values, ", "), args...)
+	return err
+}
+
+// registerVehicle creates a placeholder vehicle for an unknown device. The
+// vehicle starts as 'pending' until its details are filled in.
+func registerVehicle(db dbExecutor, vehicleID string) error {
+	res, err := db.Exec(`
+		INSERT INTO vehicles (id, vehicle_number, driver_name, vehicle_type, phone, status)
+		VALUES ($1, $1, 'Unassigned', 'unknown', '', 'pending')
+		ON CONFLICT DO NOTHING
+	`, vehicleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errVehicleNumberConflict
+	}
+	return nil
+}
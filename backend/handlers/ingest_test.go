@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"math/rand"
+	"testing"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+)
+
+var ingestBase = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func square(minLat, minLon, size float64) models.GeofenceShape {
+	return models.GeofenceShape{
+		Type: models.GeofenceTypePolygon,
+		Coordinates: [][2]float64{
+			{minLat, minLon}, {minLat, minLon + size}, {minLat + size, minLon + size},
+			{minLat + size, minLon}, {minLat, minLon},
+		},
+	}
+}
+
+func newIngestHandler(policy string) *Handler {
+	h := &Handler{
+		Index: spatial.NewIndex(),
+		Config: Config{
+			LateLocationPolicy: policy,
+			MaxAccuracyMeters:  100,
+			MaxImpliedSpeedKmh: 300,
+		},
+	}
+	h.Index.Load([]models.Geofence{
+		{ID: "geo_a", Name: "A", Category: "delivery_zone", GeofenceShape: square(12.90, 77.50, 0.01)},
+		{ID: "geo_b", Name: "B", Category: "delivery_zone", GeofenceShape: square(12.90, 77.52, 0.01)},
+	})
+	return h
+}
+
+// route drives out of A, through A and B and out again, one fix a minute.
+func route() []UpdateLocationRequest {
+	lons := []float64{77.495, 77.505, 77.507, 77.515, 77.525, 77.535}
+	points := make([]UpdateLocationRequest, len(lons))
+	for i, lon := range lons {
+		points[i] = UpdateLocationRequest{
+			VehicleID: "veh_1",
+			Latitude:  12.905,
+			Longitude: lon,
+			Timestamp: ingestBase.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return points
+}
+
+type membershipEvent struct {
+	GeofenceID string
+	EventType  string
+	Timestamp  time.Time
+}
+
+// replayMemberships runs the evaluated points through a plain entry/exit
+// diff, as detectAndHandleEvents does without hysteresis, starting from the
+// given memberships.
+func replayMemberships(h *Handler, points []UpdateLocationRequest, results []LocationResult, stored []int, inside map[string]bool) []membershipEvent {
+	events := []membershipEvent{}
+	for _, i := range evaluatedLocations(stored, results) {
+		current := map[string]bool{}
+		for _, g := range h.getGeofencesContainingPoint(points[i].Latitude, points[i].Longitude) {
+			current[g.GeofenceID] = true
+			if !inside[g.GeofenceID] {
+				events = append(events, membershipEvent{g.GeofenceID, "entry", points[i].Timestamp})
+			}
+		}
+		for id := range inside {
+			if !current[id] {
+				events = append(events, membershipEvent{id, "exit", points[i].Timestamp})
+			}
+		}
+		inside = current
+	}
+	return events
+}
+
+func shuffled(points []UpdateLocationRequest, rng *rand.Rand) []UpdateLocationRequest {
+	out := append([]UpdateLocationRequest(nil), points...)
+	rng.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+func TestPlanLocationsOrdersShuffledBatch(t *testing.T) {
+	h := newIngestHandler(LatePolicyStore)
+	ordered := route()
+	want := []membershipEvent{
+		{"geo_a", "entry", ordered[1].Timestamp},
+		{"geo_a", "exit", ordered[3].Timestamp},
+		{"geo_b", "entry", ordered[4].Timestamp},
+		{"geo_b", "exit", ordered[5].Timestamp},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 50; run++ {
+		points := shuffled(ordered, rng)
+		results, stored := h.planLocations("veh_1", points, sql.NullTime{}, nil)
+
+		if len(stored) != len(points) {
+			t.Fatalf("run %d: stored %d of %d points", run, len(stored), len(points))
+		}
+		for k := 1; k < len(stored); k++ {
+			if points[stored[k]].Timestamp.Before(points[stored[k-1]].Timestamp) {
+				t.Fatalf("run %d: stored points out of event-time order at %d", run, k)
+			}
+		}
+		for i, res := range results {
+			if !res.Accepted || res.Late || res.FlagReason != "" || res.Index != i {
+				t.Fatalf("run %d: unexpected result %+v", run, res)
+			}
+		}
+
+		got := replayMemberships(h, points, results, stored, map[string]bool{})
+		if len(got) != len(want) {
+			t.Fatalf("run %d: got events %v, want %v", run, got, want)
+		}
+		for k := range want {
+			if got[k] != want[k] {
+				t.Fatalf("run %d: event %d is %v, want %v", run, k, got[k], want[k])
+			}
+		}
+	}
+}
+
+func TestPlanLocationsLatePoints(t *testing.T) {
+	ordered := route()
+	// The vehicle was last evaluated inside A at the third fix
+	lastAt := sql.NullTime{Time: ordered[2].Timestamp, Valid: true}
+	prev := &trackPoint{Latitude: ordered[2].Latitude, Longitude: ordered[2].Longitude, Timestamp: ordered[2].Timestamp}
+
+	// The first two fixes arrive late, mixed with the later ones
+	late := map[int]bool{0: true, 1: true}
+	batch := []UpdateLocationRequest{ordered[0], ordered[1], ordered[3], ordered[4], ordered[5]}
+
+	t.Run("store", func(t *testing.T) {
+		h := newIngestHandler(LatePolicyStore)
+		rng := rand.New(rand.NewSource(2))
+		for run := 0; run < 20; run++ {
+			order := rng.Perm(len(batch))
+			points := make([]UpdateLocationRequest, len(batch))
+			for k, from := range order {
+				points[k] = batch[from]
+			}
+
+			results, stored := h.planLocations("veh_1", points, lastAt, prev)
+			if len(stored) != len(points) {
+				t.Fatalf("run %d: stored %d of %d points", run, len(stored), len(points))
+			}
+			for k, from := range order {
+				if results[k].Late != late[from] || !results[k].Accepted || results[k].Error != "" {
+					t.Fatalf("run %d: point %d: unexpected result %+v", run, from, results[k])
+				}
+			}
+
+			got := replayMemberships(h, points, results, stored, map[string]bool{"geo_a": true})
+			want := []membershipEvent{
+				{"geo_a", "exit", ordered[3].Timestamp},
+				{"geo_b", "entry", ordered[4].Timestamp},
+				{"geo_b", "exit", ordered[5].Timestamp},
+			}
+			if len(got) != len(want) {
+				t.Fatalf("run %d: got events %v, want %v", run, got, want)
+			}
+			for k := range want {
+				if got[k] != want[k] {
+					t.Fatalf("run %d: event %d is %v, want %v", run, k, got[k], want[k])
+				}
+			}
+		}
+	})
+
+	t.Run("store late only", func(t *testing.T) {
+		h := newIngestHandler(LatePolicyStore)
+		points := []UpdateLocationRequest{ordered[1], ordered[0]}
+
+		results, stored := h.planLocations("veh_1", points, lastAt, prev)
+		if len(stored) != 2 || !results[0].Late || !results[1].Late {
+			t.Fatalf("late points should be stored as late, got %+v", results)
+		}
+		if evaluated := evaluatedLocations(stored, results); len(evaluated) != 0 {
+			t.Fatalf("late points must not be evaluated, got %v", evaluated)
+		}
+		inside := map[string]bool{"geo_a": true}
+		if got := replayMemberships(h, points, results, stored, inside); len(got) != 0 {
+			t.Fatalf("late points changed memberships: %v", got)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		h := newIngestHandler(LatePolicyReject)
+		points := shuffled(batch, rand.New(rand.NewSource(3)))
+
+		results, stored := h.planLocations("veh_1", points, lastAt, prev)
+		if len(stored) != len(batch)-len(late) {
+			t.Fatalf("stored %d points, want %d", len(stored), len(batch)-len(late))
+		}
+		for i, p := range points {
+			isLate := p.Timestamp.Before(lastAt.Time)
+			if isLate && (results[i].Accepted || results[i].Error == "") {
+				t.Errorf("late point %d should be rejected with an error, got %+v", i, results[i])
+			}
+			if !isLate && (!results[i].Accepted || results[i].Error != "") {
+				t.Errorf("point %d should be accepted, got %+v", i, results[i])
+			}
+		}
+	})
+}
+
+func TestNormalizeLocationUsesUTC(t *testing.T) {
+	h := newIngestHandler(LatePolicyStore)
+	lastAt := sql.NullTime{Time: time.Date(2024, 3, 1, 4, 30, 0, 0, time.UTC), Valid: true}
+
+	// 10:01 at +05:30 is 04:31 UTC, one minute after the last fix
+	p := UpdateLocationRequest{
+		VehicleID: "veh_1",
+		Latitude:  12.905,
+		Longitude: 77.495,
+		Timestamp: time.Date(2024, 3, 1, 10, 1, 0, 0, time.FixedZone("IST", 5*3600+1800)),
+	}
+	normalizeLocation(&p)
+
+	if p.Timestamp.Location() != time.UTC {
+		t.Fatalf("timestamp not converted to UTC: %v", p.Timestamp)
+	}
+	if !p.Timestamp.Equal(lastAt.Time.Add(time.Minute)) {
+		t.Fatalf("timestamp changed instant: %v", p.Timestamp)
+	}
+	results, _ := h.planLocations("veh_1", []UpdateLocationRequest{p}, lastAt, nil)
+	if results[0].Late {
+		t.Fatalf("point after the last fix classified as late")
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -16,11 +17,17 @@ type UpdateLocationRequest struct {
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 	Timestamp time.Time `json:"timestamp"`
+	// Optional device readings. When speed is omitted it is derived from
+	// the previous point.
+	models.Telemetry
 }
 
 type UpdateLocationResponse struct {
 	VehicleID        string                  `json:"vehicle_id"`
 	LocationUpdated  bool                    `json:"location_updated"`
+	Registered       bool                    `json:"vehicle_registered,omitempty"`
+	Late             bool                    `json:"late,omitempty"`
+	FlagReason       string                  `json:"flag_reason,omitempty"`
 	CurrentGeofences []models.GeofenceStatus `json:"current_geofences"`
 	TimeNs           string                  `json:"time_ns"`
 }
@@ -32,6 +39,7 @@ type GetLocationResponse struct {
 		Latitude  float64   `json:"latitude"`
 		Longitude float64   `json:"longitude"`
 		Timestamp time.Time `json:"timestamp"`
+		models.Telemetry
 	} `json:"current_location"`
 	CurrentGeofences []models.GeofenceStatus `json:"current_geofences"`
 	TimeNs           string                  `json:"time_ns"`
@@ -46,41 +54,49 @@ func (h *Handler) UpdateVehicleLocation(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Validate latitude and longitude
-	if req.Latitude < -90 || req.Latitude > 90 {
-		http.Error(w, "Latitude must be between -90 and 90", http.StatusBadRequest)
+	if err := validateLocation(req); err == errMissingVehicleID {
+		writeError(w, http.StatusUnprocessableEntity, "invalid_vehicle_id", err.Error(), nil)
 		return
-	}
-	if req.Longitude < -180 || req.Longitude > 180 {
-		http.Error(w, "Longitude must be between -180 and 180", http.StatusBadRequest)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	normalizeLocation(&req)
 
-	// Insert location update
-	_, err := h.DB.Exec(`
-		INSERT INTO vehicle_locations (vehicle_id, latitude, longitude, geom, timestamp)
-		VALUES ($1, $2, $3, ST_SetSRID(ST_MakePoint($4, $5), 4326), $6)
-	`, req.VehicleID, req.Latitude, req.Longitude, req.Longitude, req.Latitude, req.Timestamp)
-
+	results, alerts, err := h.ingestVehicleLocations(req.VehicleID, []UpdateLocationRequest{req})
+	if err == errVehicleNotFound {
+		writeError(w, http.StatusNotFound, "vehicle_not_found", "Vehicle not found", map[string]string{"vehicle_id": req.VehicleID})
+		return
+	}
+	if err == errVehicleNumberConflict {
+		writeError(w, http.StatusConflict, "vehicle_number_conflict", "Cannot auto-register vehicle: "+err.Error(),
+			map[string]string{"vehicle_id": req.VehicleID})
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update location: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Get current geofences containing the vehicle
-	currentGeofences := h.getGeofencesContainingPoint(req.Latitude, req.Longitude)
+	h.broadcastAlerts(alerts)
 
-	// Get previous geofences
-	previousGeofences := h.getPreviousGeofences(req.VehicleID)
-
-	// Detect entry/exit events
-	h.detectAndHandleEvents(req.VehicleID, req.Latitude, req.Longitude, req.Timestamp, previousGeofences, currentGeofences)
+	if !results[0].Accepted {
+		http.Error(w, results[0].Error, http.StatusConflict)
+		return
+	}
+	currentGeofences := results[0].CurrentGeofences
+	if currentGeofences == nil {
+		currentGeofences = []models.GeofenceStatus{}
+	}
 
 	elapsed := time.Since(start).Nanoseconds()
 
 	response := UpdateLocationResponse{
 		VehicleID:        req.VehicleID,
 		LocationUpdated:  true,
+		Registered:       results[0].Registered,
+		Late:             results[0].Late,
+		FlagReason:       results[0].FlagReason,
 		CurrentGeofences: currentGeofences,
 		TimeNs:           fmt.Sprintf("%d", elapsed),
 	}
@@ -106,13 +122,14 @@ func (h *Handler) GetVehicleLocation(w http.ResponseWriter, r *http.Request) {
 	// Get latest location
 	var lat, lon float64
 	var timestamp time.Time
+	var telemetry models.Telemetry
 	err = h.DB.QueryRow(`
-		SELECT latitude, longitude, timestamp
+		SELECT latitude, longitude, timestamp, `+telemetryColumns+`
 		FROM vehicle_locations
-		WHERE vehicle_id = $1
+		WHERE vehicle_id = $1 AND NOT flagged
 		ORDER BY timestamp DESC
 		LIMIT 1
-	`, vehicleID).Scan(&lat, &lon, &timestamp)
+	`, vehicleID).Scan(append([]interface{}{&lat, &lon, &timestamp}, telemetryScanArgs(&telemetry)...)...)
 
 	currentGeofences := []models.GeofenceStatus{}
 	if err == nil {
@@ -131,136 +148,343 @@ func (h *Handler) GetVehicleLocation(w http.ResponseWriter, r *http.Request) {
 	response.CurrentLocation.Latitude = lat
 	response.CurrentLocation.Longitude = lon
 	response.CurrentLocation.Timestamp = timestamp
+	response.CurrentLocation.Telemetry = telemetry
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// getGeofencesContainingPoint evaluates the point against the in-memory
+// geofence index.
 func (h *Handler) getGeofencesContainingPoint(lat, lon float64) []models.GeofenceStatus {
-	rows, err := h.DB.Query(`
-		SELECT id, name, category
-		FROM geofences
-		WHERE ST_Contains(geom, ST_SetSRID(ST_MakePoint($1, $2), 4326))
-	`, lon, lat)
-
-	if err != nil {
-		return []models.GeofenceStatus{}
-	}
-	defer rows.Close()
-
 	geofences := []models.GeofenceStatus{}
-	for rows.Next() {
-		var g models.GeofenceStatus
-		rows.Scan(&g.GeofenceID, &g.GeofenceName, &g.Category)
-		g.Status = "inside"
-		geofences = append(geofences, g)
+	for _, e := range h.Index.Search(lat, lon) {
+		geofences = append(geofences, models.GeofenceStatus{
+			GeofenceID:   e.ID,
+			GeofenceName: e.Name,
+			Status:       "inside",
+			Category:     e.Category,
+		})
 	}
 
 	return geofences
 }
 
-func (h *Handler) getPreviousGeofences(vehicleID string) map[string]bool {
-	rows, err := h.DB.Query(`
-		SELECT DISTINCT g.id
-		FROM geofences g
-		JOIN vehicle_locations vl ON ST_Contains(g.geom, vl.geom)
-		WHERE vl.vehicle_id = $1
-		AND vl.timestamp = (
-			SELECT MAX(timestamp) FROM vehicle_locations WHERE vehicle_id = $1 AND id < (
-				SELECT MAX(id) FROM vehicle_locations WHERE vehicle_id = $1
-			)
-		)
-	`, vehicleID)
+// geofenceMembership is a row of vehicle_geofence_state joined with its
+// geofence. Inside is false while an entry is still being confirmed; a
+// non-zero PendingCount on an inside row is an exit being confirmed.
+// PendingSince and PendingLat/PendingLon are the first fix of the pending
+// transition.
+type geofenceMembership struct {
+	GeofenceName string
+	Category     string
+	EnteredAt    time.Time
+	Inside       bool
+	PendingCount int
+	PendingSince sql.NullTime
+	PendingLat   sql.NullFloat64
+	PendingLon   sql.NullFloat64
+}
 
-	previous := make(map[string]bool)
+func loadGeofenceMemberships(db dbExecutor, vehicleID string) (map[string]geofenceMembership, error) {
+	rows, err := db.Query(`
+		SELECT s.geofence_id, g.name, g.category, s.entered_at, s.inside, s.pending_count, s.pending_since,
+			s.pending_latitude, s.pending_longitude
+		FROM vehicle_geofence_state s
+		JOIN geofences g ON s.geofence_id = g.id
+		WHERE s.vehicle_id = $1
+	`, vehicleID)
 	if err != nil {
-		return previous
+		return nil, err
 	}
 	defer rows.Close()
 
+	memberships := make(map[string]geofenceMembership)
 	for rows.Next() {
 		var geoID string
-		rows.Scan(&geoID)
-		previous[geoID] = true
+		var m geofenceMembership
+		if err := rows.Scan(&geoID, &m.GeofenceName, &m.Category, &m.EnteredAt, &m.Inside, &m.PendingCount, &m.PendingSince, &m.PendingLat, &m.PendingLon); err != nil {
+			return nil, err
+		}
+		memberships[geoID] = m
 	}
 
-	return previous
+	return memberships, rows.Err()
 }
 
-func (h *Handler) detectAndHandleEvents(vehicleID string, lat, lon float64, timestamp time.Time, previousGeofences map[string]bool, currentGeofences []models.GeofenceStatus) {
+// detectAndHandleEvents diffs the vehicle's stored memberships against the
+// geofences containing the new point, writes the new memberships and records
+// entry/exit events. Transitions go through the geofence's hysteresis: an
+// inside vehicle stays inside while within the exit buffer, and entries and
+// exits only count after enough consecutive confirming fixes (and, for
+// exits, enough time outside). The alerts to broadcast are returned so the
+// caller can send them once the transaction has committed.
+func (h *Handler) detectAndHandleEvents(tx dbExecutor, vehicleID string, lat, lon float64, timestamp time.Time, currentGeofences []models.GeofenceStatus) ([][]byte, error) {
+	previous, err := loadGeofenceMemberships(tx, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
 	currentMap := make(map[string]models.GeofenceStatus)
 	for _, g := range currentGeofences {
 		currentMap[g.GeofenceID] = g
 	}
 
-	// Detect entries
-	for geoID, g := range currentMap {
-		if !previousGeofences[geoID] {
-			// Entry event
-			h.handleGeofenceEvent(vehicleID, g.GeofenceID, g.GeofenceName, g.Category, "entry", lat, lon, timestamp)
+	alerts := [][]byte{}
+	emit := func(geoID, name, category, eventType string) error {
+		alert, err := h.handleGeofenceEvent(tx, violationEvent{
+			VehicleID:    vehicleID,
+			GeofenceID:   geoID,
+			GeofenceName: name,
+			Category:     category,
+			EventType:    eventType,
+			Latitude:     lat,
+			Longitude:    lon,
+			Timestamp:    timestamp,
+		})
+		if alert != nil {
+			alerts = append(alerts, alert)
+		}
+		return err
+	}
+
+	// Advance known memberships
+	for geoID, m := range previous {
+		entry := h.Index.Get(geoID)
+		hs := h.hysteresisFor(entry)
+		_, contained := currentMap[geoID]
+
+		// Transitions take effect at the first fix that started them
+		pendingSince, pendingLat, pendingLon := timestamp, lat, lon
+		if m.PendingSince.Valid {
+			pendingSince = m.PendingSince.Time
+		}
+		if m.PendingLat.Valid && m.PendingLon.Valid {
+			pendingLat, pendingLon = m.PendingLat.Float64, m.PendingLon.Float64
+		}
+
+		if m.Inside {
+			stillInside := contained || (entry != nil && entry.Within(lat, lon, hs.ExitBufferMeters))
+			if stillInside {
+				if m.PendingCount > 0 {
+					if err := resetPendingTransition(tx, vehicleID, geoID); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+
+			count := m.PendingCount + 1
+			if count >= hs.ConfirmFixes && timestamp.Sub(pendingSince) >= hs.MinExitDuration {
+				_, err := tx.Exec(`DELETE FROM vehicle_geofence_state WHERE vehicle_id = $1 AND geofence_id = $2`, vehicleID, geoID)
+				if err != nil {
+					return nil, err
+				}
+				if err := closeVisit(tx, vehicleID, geoID, pendingSince, pendingLat, pendingLon); err != nil {
+					return nil, err
+				}
+				if err := h.chargeToll(tx, vehicleID, geoID, m.EnteredAt, pendingSince); err != nil {
+					return nil, err
+				}
+				if err := emit(geoID, m.GeofenceName, m.Category, "exit"); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := setPendingTransition(tx, vehicleID, geoID, count, pendingSince, pendingLat, pendingLon); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Entry being confirmed
+		if !contained {
+			_, err := tx.Exec(`DELETE FROM vehicle_geofence_state WHERE vehicle_id = $1 AND geofence_id = $2`, vehicleID, geoID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		count := m.PendingCount + 1
+		if count >= hs.ConfirmFixes {
+			_, err := tx.Exec(`
+				UPDATE vehicle_geofence_state
+				SET inside = TRUE, entered_at = $3, pending_count = 0, pending_since = NULL,
+					pending_latitude = NULL, pending_longitude = NULL, updated_at = CURRENT_TIMESTAMP
+				WHERE vehicle_id = $1 AND geofence_id = $2
+			`, vehicleID, geoID, pendingSince)
+			if err != nil {
+				return nil, err
+			}
+			if err := openVisit(tx, vehicleID, geoID, pendingSince, pendingLat, pendingLon); err != nil {
+				return nil, err
+			}
+			if err := emit(geoID, m.GeofenceName, m.Category, "entry"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := setPendingTransition(tx, vehicleID, geoID, count, pendingSince, pendingLat, pendingLon); err != nil {
+			return nil, err
 		}
 	}
 
-	// Detect exits
-	for geoID := range previousGeofences {
-		if _, exists := currentMap[geoID]; !exists {
-			// Exit event - need to get geofence details
-			var name, category string
-			h.DB.QueryRow(`SELECT name, category FROM geofences WHERE id = $1`, geoID).Scan(&name, &category)
-			h.handleGeofenceEvent(vehicleID, geoID, name, category, "exit", lat, lon, timestamp)
+	// Detect new entries
+	for geoID, g := range currentMap {
+		if _, exists := previous[geoID]; exists {
+			continue
+		}
+
+		hs := h.hysteresisFor(h.Index.Get(geoID))
+		if hs.ConfirmFixes <= 1 {
+			_, err := tx.Exec(`
+				INSERT INTO vehicle_geofence_state (vehicle_id, geofence_id, entered_at)
+				VALUES ($1, $2, $3)
+			`, vehicleID, geoID, timestamp)
+			if err != nil {
+				return nil, err
+			}
+			if err := openVisit(tx, vehicleID, geoID, timestamp, lat, lon); err != nil {
+				return nil, err
+			}
+			if err := emit(geoID, g.GeofenceName, g.Category, "entry"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO vehicle_geofence_state (vehicle_id, geofence_id, entered_at, inside, pending_count, pending_since,
+				pending_latitude, pending_longitude)
+			VALUES ($1, $2, $3, FALSE, 1, $3, $4, $5)
+		`, vehicleID, geoID, timestamp, lat, lon)
+		if err != nil {
+			return nil, err
 		}
 	}
+
+	return alerts, nil
+}
+
+func setPendingTransition(db dbExecutor, vehicleID, geofenceID string, count int, since time.Time, lat, lon float64) error {
+	_, err := db.Exec(`
+		UPDATE vehicle_geofence_state
+		SET pending_count = $3, pending_since = $4, pending_latitude = $5, pending_longitude = $6,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE vehicle_id = $1 AND geofence_id = $2
+	`, vehicleID, geofenceID, count, since, lat, lon)
+	return err
+}
+
+func resetPendingTransition(db dbExecutor, vehicleID, geofenceID string) error {
+	_, err := db.Exec(`
+		UPDATE vehicle_geofence_state
+		SET pending_count = 0, pending_since = NULL, pending_latitude = NULL, pending_longitude = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE vehicle_id = $1 AND geofence_id = $2
+	`, vehicleID, geofenceID)
+	return err
 }
 
-func (h *Handler) handleGeofenceEvent(vehicleID, geofenceID, geofenceName, category, eventType string, lat, lon float64, timestamp time.Time) {
+// handleGeofenceEvent records a violation when an alert is configured for
+// the event and returns the WebSocket message for it, or nil. It runs inside
+// the caller's transaction, so errors must abort the caller.
+func (h *Handler) handleGeofenceEvent(db dbExecutor, ev violationEvent) ([]byte, error) {
 	// Check if there's an alert configured for this event
 	var alertExists bool
-	err := h.DB.QueryRow(`
+	err := db.QueryRow(`
 		SELECT EXISTS(
 			SELECT 1 FROM alerts
-			WHERE geofence_id = $1
+			WHERE (geofence_id = $1 OR geofence_category = $4 OR (geofence_id IS NULL AND geofence_category IS NULL))
 			AND (vehicle_id = $2 OR vehicle_id IS NULL)
-			AND (event_type = $3 OR event_type = 'both')
+			AND (event_type = $3 OR (event_type = 'both' AND $3 IN ('entry', 'exit')))
 			AND status = 'active'
 		)
-	`, geofenceID, vehicleID, eventType).Scan(&alertExists)
+	`, ev.GeofenceID, ev.VehicleID, ev.EventType, ev.Category).Scan(&alertExists)
 
 	if err != nil || !alertExists {
-		return
+		return nil, err
 	}
 
+	return recordViolation(db, ev)
+}
+
+// violationEvent is a geofence event that matched an alert configuration.
+type violationEvent struct {
+	VehicleID    string
+	GeofenceID   string
+	GeofenceName string
+	Category     string
+	EventType    string
+	Latitude     float64
+	Longitude    float64
+	Timestamp    time.Time
+	// DwellSeconds is set for dwell events.
+	DwellSeconds *int
+	// SpeedKmh, SpeedLimitKmh and SpeedSource are set for speeding events.
+	SpeedKmh      *float64
+	SpeedLimitKmh *float64
+	SpeedSource   string
+}
+
+// recordViolation stores the violation and returns its WebSocket message.
+func recordViolation(db dbExecutor, ev violationEvent) ([]byte, error) {
 	// Store violation
 	violationID := "viol_" + uuid.New().String()[:8]
-	h.DB.Exec(`
-		INSERT INTO violations (id, vehicle_id, geofence_id, event_type, latitude, longitude, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, violationID, vehicleID, geofenceID, eventType, lat, lon, timestamp)
+	_, err := db.Exec(`
+		INSERT INTO violations (id, vehicle_id, geofence_id, event_type, latitude, longitude, timestamp,
+			dwell_seconds, speed_kmh, speed_limit_kmh)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, violationID, ev.VehicleID, ev.GeofenceID, ev.EventType, ev.Latitude, ev.Longitude, ev.Timestamp,
+		ev.DwellSeconds, ev.SpeedKmh, ev.SpeedLimitKmh)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get vehicle details
 	var vehicleNumber, driverName string
-	h.DB.QueryRow(`SELECT vehicle_number, driver_name FROM vehicles WHERE id = $1`, vehicleID).Scan(&vehicleNumber, &driverName)
+	err = db.QueryRow(`SELECT vehicle_number, driver_name FROM vehicles WHERE id = $1`, ev.VehicleID).Scan(&vehicleNumber, &driverName)
+	if err != nil {
+		return nil, err
+	}
 
-	// Send WebSocket alert
+	// Build WebSocket alert
 	alert := map[string]interface{}{
 		"event_id":   "evt_" + uuid.New().String()[:8],
-		"event_type": eventType,
-		"timestamp":  timestamp,
+		"event_type": ev.EventType,
+		"timestamp":  ev.Timestamp,
 		"vehicle": map[string]string{
-			"vehicle_id":     vehicleID,
+			"vehicle_id":     ev.VehicleID,
 			"vehicle_number": vehicleNumber,
 			"driver_name":    driverName,
 		},
 		"geofence": map[string]string{
-			"geofence_id":   geofenceID,
-			"geofence_name": geofenceName,
-			"category":      category,
+			"geofence_id":   ev.GeofenceID,
+			"geofence_name": ev.GeofenceName,
+			"category":      ev.Category,
 		},
 		"location": map[string]float64{
-			"latitude":  lat,
-			"longitude": lon,
+			"latitude":  ev.Latitude,
+			"longitude": ev.Longitude,
 		},
 	}
+	if ev.DwellSeconds != nil {
+		alert["dwell_seconds"] = *ev.DwellSeconds
+	}
+	if ev.SpeedKmh != nil && ev.SpeedLimitKmh != nil {
+		alert["speed"] = map[string]interface{}{
+			"measured_kmh": *ev.SpeedKmh,
+			"limit_kmh":    *ev.SpeedLimitKmh,
+			"source":       ev.SpeedSource,
+		}
+	}
 
-	alertJSON, _ := json.Marshal(alert)
-	h.Hub.Broadcast <- alertJSON
+	return json.Marshal(alert)
+}
+
+func (h *Handler) broadcastAlerts(alerts [][]byte) {
+	for _, alert := range alerts {
+		h.Hub.Broadcast <- alert
+	}
 }
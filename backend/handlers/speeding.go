@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"time"
+
+	"geofencing-system/spatial"
+)
+
+// Highest speed accepted for reported speeds and speed limits, in km/h.
+const maxSpeedKmh = 500
+
+const (
+	speedSourceReported = "reported"
+	speedSourceDerived  = "derived"
+)
+
+// trackPoint is a position of a vehicle at a point in time.
+type trackPoint struct {
+	Latitude  float64
+	Longitude float64
+	Timestamp time.Time
+}
+
+// effectiveSpeed returns the reported speed of p or, when none is reported,
+// the speed implied by the distance from the previous point. ok is false when
+// neither is available.
+func effectiveSpeed(p UpdateLocationRequest, prev *trackPoint) (kmh float64, source string, ok bool) {
+	if p.Speed != nil {
+		return *p.Speed, speedSourceReported, true
+	}
+	if prev == nil {
+		return 0, "", false
+	}
+	elapsed := p.Timestamp.Sub(prev.Timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0, "", false
+	}
+	meters := spatial.DistanceMeters(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+	return meters / elapsed * 3.6, speedSourceDerived, true
+}
+
+// detectSpeeding compares the speed against the limit of every speed-limit
+// geofence the vehicle is inside. A speeding event is raised once when the
+// vehicle goes over the limit and re-armed when it drops back under it.
+func (h *Handler) detectSpeeding(tx dbExecutor, vehicleID string, p UpdateLocationRequest, speedKmh float64, source string) ([][]byte, error) {
+	rows, err := tx.Query(`
+		SELECT s.geofence_id, g.name, g.category, s.speeding
+		FROM vehicle_geofence_state s
+		JOIN geofences g ON s.geofence_id = g.id
+		WHERE s.vehicle_id = $1 AND s.inside AND g.speed_limit_kmh IS NOT NULL
+	`, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	type zone struct {
+		geofenceID, name, category string
+		speeding                   bool
+	}
+	zones := []zone{}
+	for rows.Next() {
+		var z zone
+		if err := rows.Scan(&z.geofenceID, &z.name, &z.category, &z.speeding); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	alerts := [][]byte{}
+	for _, z := range zones {
+		entry := h.Index.Get(z.geofenceID)
+		if entry == nil || entry.SpeedLimitKmh == nil {
+			continue
+		}
+		limit := *entry.SpeedLimitKmh
+		speeding := speedKmh > limit
+		if speeding == z.speeding {
+			continue
+		}
+
+		_, err := tx.Exec(`
+			UPDATE vehicle_geofence_state SET speeding = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE vehicle_id = $1 AND geofence_id = $2
+		`, vehicleID, z.geofenceID, speeding)
+		if err != nil {
+			return nil, err
+		}
+		if !speeding {
+			continue
+		}
+
+		speed := speedKmh
+		alert, err := h.handleGeofenceEvent(tx, violationEvent{
+			VehicleID:     vehicleID,
+			GeofenceID:    z.geofenceID,
+			GeofenceName:  z.name,
+			Category:      z.category,
+			EventType:     "speeding",
+			Latitude:      p.Latitude,
+			Longitude:     p.Longitude,
+			Timestamp:     p.Timestamp,
+			SpeedKmh:      &speed,
+			SpeedLimitKmh: &limit,
+			SpeedSource:   source,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultStopRadiusMeters = 50
+	maxStopRadiusMeters     = 1000
+	// A reported speed above this means the vehicle is moving, whatever the
+	// spread of its positions.
+	stopMaxSpeedKmh = 5
+)
+
+// Stop is a period in which a vehicle stayed within the stop radius.
+type Stop struct {
+	StartTime       time.Time               `json:"start_time"`
+	EndTime         time.Time               `json:"end_time"`
+	DurationSeconds int                     `json:"duration_seconds"`
+	Latitude        float64                 `json:"latitude"`
+	Longitude       float64                 `json:"longitude"`
+	Points          int                     `json:"points"`
+	Idle            bool                    `json:"idle"`
+	Unplanned       bool                    `json:"unplanned"`
+	Geofences       []models.GeofenceStatus `json:"geofences"`
+}
+
+type GetStopsResponse struct {
+	VehicleID    string    `json:"vehicle_id"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	MinMinutes   int       `json:"min_minutes"`
+	RadiusMeters float64   `json:"radius_meters"`
+	Stops        []Stop    `json:"stops"`
+	TotalCount   int       `json:"total_count"`
+	Truncated    bool      `json:"truncated,omitempty"`
+	TimeNs       string    `json:"time_ns"`
+}
+
+// GetVehicleStops lists the places a vehicle stayed for at least min_minutes
+// (default TRIP_STOP_MINUTES) within radius meters. Each stop is labelled with
+// the geofences it falls in; a stop outside every geofence is unplanned, and a
+// stop with the ignition reported on is idling.
+func (h *Handler) GetVehicleStops(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	start, end, err := parseTimeRange(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	minMinutes := int(h.Config.TripStopDuration / time.Minute)
+	if s := query.Get("min_minutes"); s != "" {
+		minMinutes, err = strconv.Atoi(s)
+		if err != nil || minMinutes <= 0 {
+			http.Error(w, "min_minutes must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	radius := float64(defaultStopRadiusMeters)
+	if s := query.Get("radius"); s != "" {
+		radius, err = strconv.ParseFloat(s, 64)
+		if err != nil || radius <= 0 || radius > maxStopRadiusMeters {
+			http.Error(w, fmt.Sprintf("radius must be between 0 and %d meters", maxStopRadiusMeters), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var exists bool
+	err = h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1)`, vehicleID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	points, truncated, err := loadTrack(h.DB, vehicleID, start, end, maxTrackPoints)
+	if err != nil {
+		http.Error(w, "Failed to fetch locations", http.StatusInternalServerError)
+		return
+	}
+
+	stops := h.detectStops(points, time.Duration(minMinutes)*time.Minute, radius)
+
+	elapsed := time.Since(begin).Nanoseconds()
+
+	response := GetStopsResponse{
+		VehicleID:    vehicleID,
+		Start:        start,
+		End:          end,
+		MinMinutes:   minMinutes,
+		RadiusMeters: radius,
+		Stops:        stops,
+		TotalCount:   len(stops),
+		Truncated:    truncated,
+		TimeNs:       fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// detectStops groups consecutive points that stay within radius of the first
+// point of the group, without a reported speed above stopMaxSpeedKmh, and
+// returns the groups lasting at least minDuration.
+func (h *Handler) detectStops(points []TrackPoint, minDuration time.Duration, radius float64) []Stop {
+	stops := []Stop{}
+
+	for i := 0; i < len(points); {
+		first := points[i]
+		j := i + 1
+		for j < len(points) {
+			p := points[j]
+			if p.Speed != nil && *p.Speed > stopMaxSpeedKmh {
+				break
+			}
+			if spatial.DistanceMeters(first.Latitude, first.Longitude, p.Latitude, p.Longitude) > radius {
+				break
+			}
+			j++
+		}
+
+		last := points[j-1]
+		if duration := last.Timestamp.Sub(first.Timestamp); duration >= minDuration {
+			stop := Stop{
+				StartTime:       first.Timestamp,
+				EndTime:         last.Timestamp,
+				DurationSeconds: int(duration.Seconds()),
+				Points:          j - i,
+			}
+			for _, p := range points[i:j] {
+				stop.Latitude += p.Latitude
+				stop.Longitude += p.Longitude
+				if p.Ignition != nil && *p.Ignition {
+					stop.Idle = true
+				}
+			}
+			stop.Latitude /= float64(stop.Points)
+			stop.Longitude /= float64(stop.Points)
+
+			stop.Geofences = h.getGeofencesContainingPoint(stop.Latitude, stop.Longitude)
+			stop.Unplanned = len(stop.Geofences) == 0
+			stops = append(stops, stop)
+		}
+
+		i = j
+	}
+
+	return stops
+}
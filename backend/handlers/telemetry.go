@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"geofencing-system/models"
+)
+
+// telemetryColumns are the vehicle_locations columns holding models.Telemetry,
+// in the order used by telemetryArgs and telemetryScanArgs.
+const telemetryColumns = `speed, heading, altitude, accuracy, satellites, ignition, battery_voltage, odometer`
+
+const telemetryColumnCount = 8
+
+const (
+	minAltitudeMeters = -1000
+	maxAltitudeMeters = 20000
+	maxSatellites     = 64
+	maxBatteryVoltage = 100
+)
+
+func telemetryArgs(t models.Telemetry) []interface{} {
+	return []interface{}{t.Speed, t.Heading, t.Altitude, t.Accuracy, t.Satellites, t.Ignition, t.BatteryVoltage, t.Odometer}
+}
+
+func telemetryScanArgs(t *models.Telemetry) []interface{} {
+	return []interface{}{&t.Speed, &t.Heading, &t.Altitude, &t.Accuracy, &t.Satellites, &t.Ignition, &t.BatteryVoltage, &t.Odometer}
+}
+
+func validateTelemetry(t models.Telemetry) error {
+	if t.Speed != nil && (*t.Speed < 0 || *t.Speed > maxSpeedKmh) {
+		return fmt.Errorf("speed must be between 0 and %d km/h", maxSpeedKmh)
+	}
+	if t.Heading != nil && (*t.Heading < 0 || *t.Heading >= 360) {
+		return errors.New("heading must be between 0 and 360 degrees")
+	}
+	if t.Altitude != nil && (*t.Altitude < minAltitudeMeters || *t.Altitude > maxAltitudeMeters) {
+		return fmt.Errorf("altitude must be between %d and %d meters", minAltitudeMeters, maxAltitudeMeters)
+	}
+	if t.Accuracy != nil && *t.Accuracy < 0 {
+		return errors.New("accuracy must not be negative")
+	}
+	if t.Satellites != nil && (*t.Satellites < 0 || *t.Satellites > maxSatellites) {
+		return fmt.Errorf("satellites must be between 0 and %d", maxSatellites)
+	}
+	if t.BatteryVoltage != nil && (*t.BatteryVoltage < 0 || *t.BatteryVoltage > maxBatteryVoltage) {
+		return fmt.Errorf("battery_voltage must be between 0 and %d volts", maxBatteryVoltage)
+	}
+	if t.Odometer != nil && *t.Odometer < 0 {
+		return errors.New("odometer must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"geofencing-system/models"
+
+	"github.com/google/uuid"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type TollSummary struct {
+	VehicleID      string  `json:"vehicle_id"`
+	VehicleNumber  string  `json:"vehicle_number"`
+	Month          string  `json:"month"`
+	Currency       string  `json:"currency"`
+	Charges        int     `json:"charges"`
+	DistanceMeters float64 `json:"distance_meters"`
+	TotalAmount    float64 `json:"total_amount"`
+}
+
+type GetTollSummaryResponse struct {
+	Summaries []TollSummary `json:"summaries"`
+	TimeNs    string        `json:"time_ns"`
+}
+
+type GetTollChargesResponse struct {
+	Charges    []models.TollCharge `json:"charges"`
+	TotalCount int                 `json:"total_count"`
+	TimeNs     string              `json:"time_ns"`
+}
+
+func validateTollTariff(t models.TollTariff) error {
+	if t.Mode != models.TollModePerEntry && t.Mode != models.TollModePerDistance {
+		return errors.New("toll.mode must be one of: per_entry, per_distance")
+	}
+	if !currencyPattern.MatchString(t.Currency) {
+		return errors.New("toll.currency must be a 3-letter ISO 4217 code")
+	}
+	if len(t.Rates) == 0 && t.DefaultRate == nil {
+		return errors.New("toll needs rates or a default_rate")
+	}
+	for vehicleType, rate := range t.Rates {
+		if rate < 0 {
+			return fmt.Errorf("toll rate for %s must not be negative", vehicleType)
+		}
+	}
+	if t.DefaultRate != nil && *t.DefaultRate < 0 {
+		return errors.New("toll.default_rate must not be negative")
+	}
+	return nil
+}
+
+// rateFor returns the tariff rate for the vehicle type, falling back to the
+// default rate. ok is false when the type is not charged.
+func rateFor(t models.TollTariff, vehicleType string) (rate float64, ok bool) {
+	if rate, ok := t.Rates[vehicleType]; ok {
+		return rate, true
+	}
+	if t.DefaultRate != nil {
+		return *t.DefaultRate, true
+	}
+	return 0, false
+}
+
+// chargeToll records the toll for a visit of a toll zone that ended at
+// exitedAt, the first fix outside. Distance-based tariffs charge the track
+// length between entry and exit, counting only segments with both ends
+// inside the zone.
+func (h *Handler) chargeToll(tx dbExecutor, vehicleID, geofenceID string, enteredAt, exitedAt time.Time) error {
+	entry := h.Index.Get(geofenceID)
+	if entry == nil || entry.Toll == nil {
+		return nil
+	}
+	tariff := *entry.Toll
+
+	var vehicleType string
+	if err := tx.QueryRow(`SELECT vehicle_type FROM vehicles WHERE id = $1`, vehicleID).Scan(&vehicleType); err != nil {
+		return err
+	}
+	rate, ok := rateFor(tariff, vehicleType)
+	if !ok {
+		return nil
+	}
+
+	var distance float64
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(ST_DistanceSphere(track.prev_geom, track.geom)), 0)
+		FROM (
+			SELECT geom, LAG(geom) OVER (ORDER BY timestamp, id) AS prev_geom
+			FROM vehicle_locations
+			WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp < $3 AND NOT flagged
+		) track
+		JOIN geofences g ON g.id = $4
+		WHERE track.prev_geom IS NOT NULL
+		AND ST_Covers(g.geom, track.prev_geom) AND ST_Covers(g.geom, track.geom)
+	`, vehicleID, enteredAt, exitedAt, geofenceID).Scan(&distance)
+	if err != nil {
+		return err
+	}
+
+	amount := rate
+	if tariff.Mode == models.TollModePerDistance {
+		amount = rate * distance / 1000
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO toll_charges (id, vehicle_id, geofence_id, geofence_name, vehicle_type, entered_at, exited_at,
+			distance_meters, mode, rate, amount, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (vehicle_id, geofence_id, entered_at) DO NOTHING
+	`, "toll_"+uuid.New().String()[:8], vehicleID, geofenceID, entry.Name, vehicleType, enteredAt, exitedAt,
+		distance, tariff.Mode, rate, amount, tariff.Currency)
+	return err
+}
+
+// parseMonth reads a YYYY-MM month and returns its first instant and the
+// first instant of the following month, in UTC.
+func parseMonth(s string) (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01", s)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("month must be formatted as YYYY-MM")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// GetTollSummary sums toll charges per vehicle, month (of exit, UTC) and
+// currency for billing reconciliation.
+func (h *Handler) GetTollSummary(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	vehicleID := r.URL.Query().Get("vehicle_id")
+	month := r.URL.Query().Get("month")
+
+	query := `
+		SELECT c.vehicle_id, v.vehicle_number, to_char(c.exited_at, 'YYYY-MM') AS month, c.currency,
+			COUNT(*), SUM(c.distance_meters), SUM(c.amount)
+		FROM toll_charges c
+		JOIN vehicles v ON c.vehicle_id = v.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+	argCount := 1
+
+	if vehicleID != "" {
+		query += fmt.Sprintf(" AND c.vehicle_id = $%d", argCount)
+		args = append(args, vehicleID)
+		argCount++
+	}
+	if month != "" {
+		from, to, err := parseMonth(month)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query += fmt.Sprintf(" AND c.exited_at >= $%d AND c.exited_at < $%d", argCount, argCount+1)
+		args = append(args, from, to)
+	}
+
+	query += `
+		GROUP BY c.vehicle_id, v.vehicle_number, month, c.currency
+		ORDER BY month DESC, v.vehicle_number, c.currency
+	`
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch toll summary", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	summaries := []TollSummary{}
+	for rows.Next() {
+		var s TollSummary
+		err := rows.Scan(&s.VehicleID, &s.VehicleNumber, &s.Month, &s.Currency, &s.Charges, &s.DistanceMeters, &s.TotalAmount)
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, s)
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := GetTollSummaryResponse{
+		Summaries: summaries,
+		TimeNs:    fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetTollCharges lists individual toll charges, newest first.
+func (h *Handler) GetTollCharges(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	vehicleID := r.URL.Query().Get("vehicle_id")
+	geofenceID := r.URL.Query().Get("geofence_id")
+	month := r.URL.Query().Get("month")
+	limitStr := r.URL.Query().Get("limit")
+
+	limit := 100
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			if l > 1000 {
+				limit = 1000
+			} else {
+				limit = l
+			}
+		}
+	}
+
+	query := `
+		SELECT c.id, c.vehicle_id, v.vehicle_number, c.vehicle_type, c.geofence_id, c.geofence_name,
+			c.entered_at, c.exited_at, c.distance_meters, c.mode, c.rate, c.amount, c.currency
+		FROM toll_charges c
+		JOIN vehicles v ON c.vehicle_id = v.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+	argCount := 1
+
+	if vehicleID != "" {
+		query += fmt.Sprintf(" AND c.vehicle_id = $%d", argCount)
+		args = append(args, vehicleID)
+		argCount++
+	}
+	if geofenceID != "" {
+		query += fmt.Sprintf(" AND c.geofence_id = $%d", argCount)
+		args = append(args, geofenceID)
+		argCount++
+	}
+	if month != "" {
+		from, to, err := parseMonth(month)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query += fmt.Sprintf(" AND c.exited_at >= $%d AND c.exited_at < $%d", argCount, argCount+1)
+		args = append(args, from, to)
+		argCount += 2
+	}
+
+	query += fmt.Sprintf(" ORDER BY c.exited_at DESC LIMIT $%d", argCount)
+	args = append(args, limit)
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch toll charges", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	charges := []models.TollCharge{}
+	for rows.Next() {
+		var c models.TollCharge
+		var geofenceID sql.NullString
+		err := rows.Scan(&c.ID, &c.VehicleID, &c.VehicleNumber, &c.VehicleType, &geofenceID, &c.GeofenceName,
+			&c.EnteredAt, &c.ExitedAt, &c.DistanceMeters, &c.Mode, &c.Rate, &c.Amount, &c.Currency)
+		if err != nil {
+			continue
+		}
+		c.GeofenceID = geofenceID.String
+		charges = append(charges, c)
+	}
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := GetTollChargesResponse{
+		Charges:    charges,
+		TotalCount: len(charges),
+		TimeNs:     fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
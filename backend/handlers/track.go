@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+
+	"github.com/gorilla/mux"
+)
+
+// Most points returned by a single track request, before downsampling.
+const maxTrackPoints = 50000
+
+type TrackPoint struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"`
+	models.Telemetry
+}
+
+type TrackResponse struct {
+	VehicleID       string       `json:"vehicle_id"`
+	Start           time.Time    `json:"start"`
+	End             time.Time    `json:"end"`
+	ToleranceMeters float64      `json:"tolerance_meters"`
+	TotalPoints     int          `json:"total_points"`
+	Truncated       bool         `json:"truncated,omitempty"`
+	Points          []TrackPoint `json:"points"`
+	TimeNs          string       `json:"time_ns"`
+}
+
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string        `xml:"name"`
+	Segment []gpxTrackPnt `xml:"trkseg>trkpt"`
+}
+
+type gpxTrackPnt struct {
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele,omitempty"`
+	Time      string   `xml:"time"`
+}
+
+// GetVehicleTrack returns the ordered locations of a vehicle between start
+// and end, optionally downsampled with Douglas-Peucker (tolerance in meters),
+// as JSON, a GeoJSON LineString feature or GPX. Flagged fixes are left out.
+func (h *Handler) GetVehicleTrack(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	start, end, err := parseTimeRange(r, 24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tolerance := 0.0
+	if s := query.Get("tolerance"); s != "" {
+		tolerance, err = strconv.ParseFloat(s, 64)
+		if err != nil || tolerance < 0 {
+			http.Error(w, "tolerance must be a non-negative number of meters", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "geojson" && format != "gpx" {
+		http.Error(w, "Invalid format. Must be one of: json, geojson, gpx", http.StatusBadRequest)
+		return
+	}
+
+	var vehicleNumber string
+	err = h.DB.QueryRow(`SELECT vehicle_number FROM vehicles WHERE id = $1`, vehicleID).Scan(&vehicleNumber)
+	if err != nil {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	points, truncated, err := loadTrack(h.DB, vehicleID, start, end, maxTrackPoints)
+	if err != nil {
+		http.Error(w, "Failed to fetch track", http.StatusInternalServerError)
+		return
+	}
+	total := len(points)
+
+	if tolerance > 0 {
+		coords := make([][2]float64, len(points))
+		for i, p := range points {
+			coords[i] = [2]float64{p.Latitude, p.Longitude}
+		}
+		kept := spatial.SimplifyTrack(coords, tolerance)
+		simplified := make([]TrackPoint, len(kept))
+		for i, k := range kept {
+			simplified[i] = points[k]
+		}
+		points = simplified
+	}
+
+	switch format {
+	case "geojson":
+		writeTrackGeoJSON(w, vehicleID, vehicleNumber, start, end, points)
+	case "gpx":
+		writeTrackGPX(w, vehicleNumber, points)
+	default:
+		response := TrackResponse{
+			VehicleID:       vehicleID,
+			Start:           start,
+			End:             end,
+			ToleranceMeters: tolerance,
+			TotalPoints:     total,
+			Truncated:       truncated,
+			Points:          points,
+			TimeNs:          fmt.Sprintf("%d", time.Since(begin).Nanoseconds()),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// loadTrack returns up to limit unflagged locations of the vehicle in
+// [start, end), ordered by time. truncated reports whether more exist.
+func loadTrack(db dbExecutor, vehicleID string, start, end time.Time, limit int) ([]TrackPoint, bool, error) {
+	rows, err := db.Query(`
+		SELECT latitude, longitude, timestamp, `+telemetryColumns+`
+		FROM vehicle_locations
+		WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp < $3 AND NOT flagged
+		ORDER BY timestamp, id
+		LIMIT $4
+	`, vehicleID, start, end, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	points := []TrackPoint{}
+	for rows.Next() {
+		p, err := scanTrackPoint(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if len(points) > limit {
+		return points[:limit], true, nil
+	}
+	return points, false, nil
+}
+
+// forEachTrackPoint streams the unflagged locations of the vehicle in
+// [start, end) to fn in time order, for ranges too long to load at once.
+func forEachTrackPoint(db dbExecutor, vehicleID string, start, end time.Time, fn func(TrackPoint)) error {
+	rows, err := db.Query(`
+		SELECT latitude, longitude, timestamp, `+telemetryColumns+`
+		FROM vehicle_locations
+		WHERE vehicle_id = $1 AND timestamp >= $2 AND timestamp < $3 AND NOT flagged
+		ORDER BY timestamp, id
+	`, vehicleID, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanTrackPoint(rows)
+		if err != nil {
+			return err
+		}
+		fn(p)
+	}
+	return rows.Err()
+}
+
+func scanTrackPoint(row rowScanner) (TrackPoint, error) {
+	var p TrackPoint
+	dest := append([]interface{}{&p.Latitude, &p.Longitude, &p.Timestamp}, telemetryScanArgs(&p.Telemetry)...)
+	err := row.Scan(dest...)
+	return p, err
+}
+
+// parseTimeRange reads the RFC 3339 start and end query parameters, in UTC
+// to match the TIMESTAMP columns. end defaults to now and start to end minus
+// defaultSpan.
+func parseTimeRange(r *http.Request, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+
+	end := time.Now().UTC()
+	if s := query.Get("end"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("end must be an RFC 3339 timestamp")
+		}
+		end = t.UTC()
+	}
+
+	start := end.Add(-defaultSpan)
+	if s := query.Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("start must be an RFC 3339 timestamp")
+		}
+		start = t.UTC()
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("start must be before end")
+	}
+	return start, end, nil
+}
+
+func writeTrackGeoJSON(w http.ResponseWriter, vehicleID, vehicleNumber string, start, end time.Time, points []TrackPoint) {
+	coordinates := make([][]float64, len(points))
+	times := make([]time.Time, len(points))
+	for i, p := range points {
+		coordinates[i] = []float64{p.Longitude, p.Latitude}
+		times[i] = p.Timestamp
+	}
+	coordinatesJSON, _ := json.Marshal(coordinates)
+
+	feature := GeoJSONFeature{
+		Type: "Feature",
+		ID:   vehicleID,
+		Properties: map[string]interface{}{
+			"vehicle_number": vehicleNumber,
+			"start":          start,
+			"end":            end,
+			"coordTimes":     times,
+		},
+		Geometry: &GeoJSONGeometry{
+			Type:        "LineString",
+			Coordinates: coordinatesJSON,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(feature)
+}
+
+func writeTrackGPX(w http.ResponseWriter, vehicleNumber string, points []TrackPoint) {
+	doc := gpxDocument{
+		Version: "1.1",
+		Creator: "geofencing-system",
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Track: gpxTrack{
+			Name:    vehicleNumber,
+			Segment: make([]gpxTrackPnt, len(points)),
+		},
+	}
+	for i, p := range points {
+		doc.Track.Segment[i] = gpxTrackPnt{
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+			Elevation: p.Altitude,
+			Time:      p.Timestamp.UTC().Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
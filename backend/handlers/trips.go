@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	tripStatusInProgress = "in_progress"
+	tripStatusCompleted  = "completed"
+)
+
+// Locations segmented per query when detecting trips.
+const tripChunkPoints = 10000
+
+type GetTripsResponse struct {
+	VehicleID  string        `json:"vehicle_id"`
+	Trips      []models.Trip `json:"trips"`
+	TotalCount int           `json:"total_count"`
+	TimeNs     string        `json:"time_ns"`
+}
+
+type RebuildTripsResponse struct {
+	VehicleID string `json:"vehicle_id"`
+	Trips     int    `json:"trips"`
+	TimeNs    string `json:"time_ns"`
+}
+
+// RunTripDetector periodically segments new locations of every vehicle into
+// trips. The first run backfills each vehicle's whole history.
+func (h *Handler) RunTripDetector(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := h.detectTrips(time.Now().UTC()); err != nil {
+			log.Printf("Trip detection failed: %v", err)
+		}
+	}
+}
+
+// detectTrips processes every vehicle with locations it has not seen yet or
+// with a trip still in progress, which may have ended by now.
+func (h *Handler) detectTrips(now time.Time) error {
+	rows, err := h.DB.Query(`
+		SELECT v.id
+		FROM vehicles v
+		WHERE v.last_location_at IS NOT NULL
+		AND (v.trips_updated_at IS DISTINCT FROM v.last_location_at
+			OR EXISTS (SELECT 1 FROM trips t WHERE t.vehicle_id = v.id AND t.status = 'in_progress'))
+	`)
+	if err != nil {
+		return err
+	}
+
+	vehicleIDs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		vehicleIDs = append(vehicleIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range vehicleIDs {
+		if _, err := h.processVehicleTrips(id, now, false); err != nil {
+			log.Printf("Trip detection failed for %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// processVehicleTrips segments the locations of a vehicle from where the
+// previous run stopped and returns the number of trips written. The trip in
+// progress is recomputed every time; completed trips are final, so late
+// locations before the cursor only show up after a rebuild, which starts
+// over from the first location.
+func (h *Handler) processVehicleTrips(vehicleID string, now time.Time, rebuild bool) (int, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('trips:' || $1))`, vehicleID); err != nil {
+		return 0, err
+	}
+
+	var cursor, lastLocationAt sql.NullTime
+	err = tx.QueryRow(`SELECT trip_cursor_at, last_location_at FROM vehicles WHERE id = $1`, vehicleID).
+		Scan(&cursor, &lastLocationAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the ID of the trip in progress stable while it is recomputed
+	var openID string
+	var openStart time.Time
+	err = tx.QueryRow(`
+		SELECT id, start_time FROM trips WHERE vehicle_id = $1 AND status = 'in_progress'
+	`, vehicleID).Scan(&openID, &openStart)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	if rebuild {
+		cursor = sql.NullTime{}
+		_, err = tx.Exec(`DELETE FROM trips WHERE vehicle_id = $1`, vehicleID)
+	} else {
+		_, err = tx.Exec(`DELETE FROM trips WHERE vehicle_id = $1 AND status = 'in_progress'`, vehicleID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	if lastLocationAt.Valid {
+		to := lastLocationAt.Time.Add(time.Microsecond)
+		trips, resumeAt, err := h.segmentTrack(cursor.Time, now, func(from time.Time) ([]TrackPoint, bool, error) {
+			return loadTrack(tx, vehicleID, from, to, tripChunkPoints)
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, trip := range trips {
+			trip.ID = "trip_" + uuid.New().String()[:8]
+			if trip.Status == tripStatusInProgress && openID != "" && trip.StartTime.Equal(openStart) {
+				trip.ID = openID
+			}
+			if err := insertTrip(tx, vehicleID, trip); err != nil {
+				return 0, err
+			}
+			written++
+		}
+		cursor = sql.NullTime{Time: resumeAt, Valid: true}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE vehicles SET trip_cursor_at = $2, trips_updated_at = last_location_at WHERE id = $1
+	`, vehicleID, cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// segmentTrack segments the track from the given time onwards, one chunk of
+// at most tripChunkPoints at a time as returned by load, which also reports
+// whether more points follow. A trip still open at the end of a chunk is
+// segmented again with the next chunk. It returns the trips and the time the
+// next run resumes from.
+func (h *Handler) segmentTrack(from, now time.Time, load func(from time.Time) ([]TrackPoint, bool, error)) ([]models.Trip, time.Time, error) {
+	all := []models.Trip{}
+	for {
+		points, truncated, err := load(from)
+		if err != nil {
+			return nil, from, err
+		}
+		if len(points) == 0 {
+			return all, from, nil
+		}
+
+		// Only the last chunk may leave a trip in progress
+		closeBefore := now
+		if truncated {
+			closeBefore = time.Time{}
+		}
+		trips, resumeAt := h.segmentTrips(points, closeBefore)
+
+		if truncated {
+			open := len(trips) > 0 && trips[len(trips)-1].Status == tripStatusInProgress
+			if !resumeAt.After(from) {
+				// No progress within a whole chunk; cut it at the chunk boundary
+				if open {
+					trips[len(trips)-1].Status = tripStatusCompleted
+				}
+				resumeAt = points[len(points)-1].Timestamp
+			} else if open {
+				// Recomputed from resumeAt with the next chunk
+				trips = trips[:len(trips)-1]
+			}
+		}
+
+		all = append(all, trips...)
+		from = resumeAt
+		if !truncated {
+			return all, from, nil
+		}
+	}
+}
+
+// tripBuilder accumulates a trip while the vehicle is moving. anchor is the
+// idle reference point the trip started from. stop is the point the vehicle
+// has stayed near since it last moved; the totals at that point are kept so
+// the trip can end there.
+type tripBuilder struct {
+	trip           models.Trip
+	anchor         TrackPoint
+	last           TrackPoint
+	stop           TrackPoint
+	distanceAtStop float64
+	maxSpeedAtStop float64
+	seen           map[string]bool
+}
+
+func (h *Handler) startTrip(anchor, from TrackPoint) *tripBuilder {
+	b := &tripBuilder{
+		anchor: anchor,
+		trip: models.Trip{
+			Status:         tripStatusInProgress,
+			StartTime:      from.Timestamp,
+			StartLatitude:  from.Latitude,
+			StartLongitude: from.Longitude,
+			Geofences:      []models.TripGeofence{},
+		},
+		last: from,
+		stop: from,
+		seen: map[string]bool{},
+	}
+	h.visitGeofences(b, from)
+	return b
+}
+
+func (h *Handler) visitGeofences(b *tripBuilder, p TrackPoint) {
+	for _, e := range h.Index.Search(p.Latitude, p.Longitude) {
+		if !b.seen[e.ID] {
+			b.seen[e.ID] = true
+			b.trip.Geofences = append(b.trip.Geofences, models.TripGeofence{GeofenceID: e.ID, GeofenceName: e.Name})
+		}
+	}
+}
+
+func (h *Handler) extendTrip(b *tripBuilder, p TrackPoint) {
+	b.trip.DistanceMeters += spatial.DistanceMeters(b.last.Latitude, b.last.Longitude, p.Latitude, p.Longitude)
+	prev := trackPoint{Latitude: b.last.Latitude, Longitude: b.last.Longitude, Timestamp: b.last.Timestamp}
+	point := UpdateLocationRequest{Latitude: p.Latitude, Longitude: p.Longitude, Timestamp: p.Timestamp, Telemetry: p.Telemetry}
+	if speed, _, ok := effectiveSpeed(point, &prev); ok && speed > b.trip.MaxSpeedKmh {
+		b.trip.MaxSpeedKmh = speed
+	}
+	h.visitGeofences(b, p)
+	b.last = p
+}
+
+// finish ends the trip at p with the given totals.
+func (b *tripBuilder) finish(p TrackPoint, distance, maxSpeed float64, status string) models.Trip {
+	trip := b.trip
+	trip.Status = status
+	trip.EndTime = p.Timestamp
+	trip.EndLatitude = p.Latitude
+	trip.EndLongitude = p.Longitude
+	trip.DistanceMeters = distance
+	trip.MaxSpeedKmh = maxSpeed
+	trip.DurationSeconds = int(trip.EndTime.Sub(trip.StartTime).Seconds())
+	return trip
+}
+
+// segmentTrips splits time-ordered points into trips. A trip starts at the
+// last point before the vehicle moves more than TripMoveMeters and ends at
+// the point where it then stays for TripStopDuration, or at the last point
+// before a reporting gap of that length. A trip still open after the last
+// point is in progress unless closeBefore is at least TripStopDuration past
+// it. resumeAt is where the next segmentation has to start to reproduce the
+// trip in progress or the current stop: segmenting again from there reaches
+// the same state, because the idle state is reset to a single point whenever
+// the anchor moves.
+func (h *Handler) segmentTrips(points []TrackPoint, closeBefore time.Time) ([]models.Trip, time.Time) {
+	move := h.Config.TripMoveMeters
+	stopAfter := h.Config.TripStopDuration
+
+	trips := []models.Trip{}
+	anchor, lastIdle := points[0], points[0]
+	var current *tripBuilder
+
+	for _, p := range points[1:] {
+		if current == nil {
+			if spatial.DistanceMeters(anchor.Latitude, anchor.Longitude, p.Latitude, p.Longitude) > move {
+				current = h.startTrip(anchor, lastIdle)
+				h.extendTrip(current, p)
+				current.stop, current.distanceAtStop, current.maxSpeedAtStop = p, current.trip.DistanceMeters, current.trip.MaxSpeedKmh
+				continue
+			}
+			lastIdle = p
+			if p.Timestamp.Sub(anchor.Timestamp) >= stopAfter {
+				anchor = p
+			}
+			continue
+		}
+
+		// The device went quiet long enough to count as a stop
+		if p.Timestamp.Sub(current.last.Timestamp) >= stopAfter {
+			trips = append(trips, current.finish(current.last, current.trip.DistanceMeters, current.trip.MaxSpeedKmh, tripStatusCompleted))
+			current = nil
+			anchor, lastIdle = p, p
+			continue
+		}
+
+		h.extendTrip(current, p)
+		if spatial.DistanceMeters(current.stop.Latitude, current.stop.Longitude, p.Latitude, p.Longitude) > move {
+			current.stop, current.distanceAtStop, current.maxSpeedAtStop = p, current.trip.DistanceMeters, current.trip.MaxSpeedKmh
+		} else if p.Timestamp.Sub(current.stop.Timestamp) >= stopAfter {
+			trips = append(trips, current.finish(current.stop, current.distanceAtStop, current.maxSpeedAtStop, tripStatusCompleted))
+			// The points since the stop are idle and p is TripStopDuration past it
+			anchor, lastIdle = p, p
+			current = nil
+		}
+	}
+
+	if current == nil {
+		return trips, anchor.Timestamp
+	}
+	if closeBefore.Sub(current.last.Timestamp) >= stopAfter {
+		trips = append(trips, current.finish(current.last, current.trip.DistanceMeters, current.trip.MaxSpeedKmh, tripStatusCompleted))
+		return trips, current.last.Timestamp
+	}
+	trips = append(trips, current.finish(current.last, current.trip.DistanceMeters, current.trip.MaxSpeedKmh, tripStatusInProgress))
+	return trips, current.anchor.Timestamp
+}
+
+func insertTrip(db dbExecutor, vehicleID string, trip models.Trip) error {
+	geofencesJSON, _ := json.Marshal(trip.Geofences)
+	_, err := db.Exec(`
+		INSERT INTO trips (id, vehicle_id, status, start_time, end_time, start_latitude, start_longitude,
+			end_latitude, end_longitude, distance_meters, max_speed_kmh, geofences)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, trip.ID, vehicleID, trip.Status, trip.StartTime, trip.EndTime, trip.StartLatitude, trip.StartLongitude,
+		trip.EndLatitude, trip.EndLongitude, trip.DistanceMeters, trip.MaxSpeedKmh, string(geofencesJSON))
+	return err
+}
+
+// GetVehicleTrips lists the trips of a vehicle that started between start
+// and end (default: the last 7 days).
+func (h *Handler) GetVehicleTrips(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := mux.Vars(r)["id"]
+
+	start, end, err := parseTimeRange(r, 7*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1)`, vehicleID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, vehicle_id, status, start_time, end_time, start_latitude, start_longitude,
+			end_latitude, end_longitude, distance_meters, max_speed_kmh, geofences
+		FROM trips
+		WHERE vehicle_id = $1 AND start_time >= $2 AND start_time < $3
+		ORDER BY start_time
+	`, vehicleID, start, end)
+	if err != nil {
+		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	trips := []models.Trip{}
+	for rows.Next() {
+		var t models.Trip
+		var geofencesJSON string
+		err := rows.Scan(&t.ID, &t.VehicleID, &t.Status, &t.StartTime, &t.EndTime, &t.StartLatitude, &t.StartLongitude,
+			&t.EndLatitude, &t.EndLongitude, &t.DistanceMeters, &t.MaxSpeedKmh, &geofencesJSON)
+		if err != nil {
+			continue
+		}
+		t.DurationSeconds = int(t.EndTime.Sub(t.StartTime).Seconds())
+		t.Geofences = []models.TripGeofence{}
+		json.Unmarshal([]byte(geofencesJSON), &t.Geofences)
+		trips = append(trips, t)
+	}
+
+	elapsed := time.Since(begin).Nanoseconds()
+
+	response := GetTripsResponse{
+		VehicleID:  vehicleID,
+		Trips:      trips,
+		TotalCount: len(trips),
+		TimeNs:     fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RebuildVehicleTrips discards the trips of a vehicle and segments its
+// whole location history again, e.g. after late locations were uploaded.
+func (h *Handler) RebuildVehicleTrips(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := mux.Vars(r)["id"]
+
+	var exists bool
+	err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM vehicles WHERE id = $1)`, vehicleID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	count, err := h.processVehicleTrips(vehicleID, time.Now().UTC(), true)
+	if err != nil {
+		http.Error(w, "Failed to rebuild trips: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	elapsed := time.Since(begin).Nanoseconds()
+
+	response := RebuildTripsResponse{
+		VehicleID: vehicleID,
+		Trips:     count,
+		TimeNs:    fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
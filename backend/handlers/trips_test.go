@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"geofencing-system/models"
+	"geofencing-system/spatial"
+)
+
+var tripBase = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+// ~108 m of longitude at this latitude, just over TripMoveMeters.
+const tripStep = 0.001
+
+func newTripHandler() *Handler {
+	return &Handler{
+		Index: spatial.NewIndex(),
+		Config: Config{
+			TripMoveMeters:   100,
+			TripStopDuration: 5 * time.Minute,
+		},
+	}
+}
+
+// trackPoint of the test track: lon in steps east of the origin, at the
+// given minute.
+func at(minute float64, steps float64) TrackPoint {
+	return TrackPoint{
+		Latitude:  12.9,
+		Longitude: 77.5 + steps*tripStep,
+		Timestamp: tripBase.Add(time.Duration(minute * float64(time.Minute))),
+	}
+}
+
+func minute(m float64) time.Time {
+	return tripBase.Add(time.Duration(m * float64(time.Minute)))
+}
+
+func TestSegmentTrips(t *testing.T) {
+	tests := []struct {
+		name        string
+		points      []TrackPoint
+		closeBefore time.Time
+		want        []models.Trip
+		resumeAt    time.Time
+	}{
+		{
+			name: "parked",
+			points: []TrackPoint{
+				at(0, 0), at(1, 0.1), at(2, 0), at(10, 0.2),
+			},
+			closeBefore: minute(10),
+			want:        []models.Trip{},
+			resumeAt:    minute(10),
+		},
+		{
+			name: "starts on movement",
+			points: []TrackPoint{
+				at(0, 0), at(1, 0), at(2, 0), at(3, 1), at(4, 2), at(5, 3),
+			},
+			closeBefore: minute(5),
+			want: []models.Trip{
+				{Status: tripStatusInProgress, StartTime: minute(2), EndTime: minute(5)},
+			},
+			resumeAt: minute(0),
+		},
+		{
+			name: "stops after TripStopDuration",
+			points: []TrackPoint{
+				at(0, 0), at(1, 1), at(2, 2), at(3, 3),
+				at(4, 3), at(5, 3), at(6, 3), at(7, 3), at(8, 3), at(9, 3),
+			},
+			closeBefore: minute(9),
+			want: []models.Trip{
+				{Status: tripStatusCompleted, StartTime: minute(0), EndTime: minute(3)},
+			},
+			resumeAt: minute(8),
+		},
+		{
+			name: "reporting gap closes the trip",
+			points: []TrackPoint{
+				at(0, 0), at(1, 1), at(2, 2),
+				at(20, 8), at(21, 8),
+			},
+			closeBefore: minute(21),
+			want: []models.Trip{
+				{Status: tripStatusCompleted, StartTime: minute(0), EndTime: minute(2)},
+			},
+			resumeAt: minute(20),
+		},
+		{
+			name: "open trip closed once closeBefore is past TripStopDuration",
+			points: []TrackPoint{
+				at(0, 0), at(1, 1), at(2, 2),
+			},
+			closeBefore: minute(8),
+			want: []models.Trip{
+				{Status: tripStatusCompleted, StartTime: minute(0), EndTime: minute(2)},
+			},
+			resumeAt: minute(2),
+		},
+		{
+			name: "two trips",
+			points: []TrackPoint{
+				at(0, 0), at(1, 1), at(2, 2),
+				at(3, 2), at(4, 2), at(5, 2), at(6, 2), at(7, 2),
+				at(8, 3), at(9, 4),
+			},
+			closeBefore: minute(9),
+			want: []models.Trip{
+				{Status: tripStatusCompleted, StartTime: minute(0), EndTime: minute(2)},
+				{Status: tripStatusInProgress, StartTime: minute(7), EndTime: minute(9)},
+			},
+			resumeAt: minute(7),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trips, resumeAt := newTripHandler().segmentTrips(tt.points, tt.closeBefore)
+			assertTrips(t, trips, tt.want)
+			if !resumeAt.Equal(tt.resumeAt) {
+				t.Errorf("resumeAt = %v, want %v", resumeAt, tt.resumeAt)
+			}
+		})
+	}
+}
+
+func TestSegmentTripsResumesInProgressTrip(t *testing.T) {
+	h := newTripHandler()
+	first := []TrackPoint{at(0, 0), at(1, 0), at(2, 1), at(3, 2)}
+	later := []TrackPoint{at(4, 3), at(5, 4), at(6, 4), at(7, 4), at(8, 4), at(9, 4), at(10, 4)}
+
+	trips, resumeAt := h.segmentTrips(first, minute(3))
+	assertTrips(t, trips, []models.Trip{
+		{Status: tripStatusInProgress, StartTime: minute(1), EndTime: minute(3)},
+	})
+	// Resumes from the idle point the trip was measured against
+	if !resumeAt.Equal(minute(0)) {
+		t.Fatalf("resumeAt = %v, want %v", resumeAt, minute(0))
+	}
+
+	// The next run segments from resumeAt, as loadTrack would return it
+	all := append(append([]TrackPoint{}, first...), later...)
+	resumed := []TrackPoint{}
+	for _, p := range all {
+		if !p.Timestamp.Before(resumeAt) {
+			resumed = append(resumed, p)
+		}
+	}
+	again, _ := h.segmentTrips(resumed, minute(10))
+	whole, _ := h.segmentTrips(all, minute(10))
+
+	want := []models.Trip{{Status: tripStatusCompleted, StartTime: minute(1), EndTime: minute(5)}}
+	assertTrips(t, again, want)
+	assertTrips(t, whole, want)
+	if again[0].DistanceMeters != whole[0].DistanceMeters {
+		t.Errorf("resumed distance %v, want %v", again[0].DistanceMeters, whole[0].DistanceMeters)
+	}
+}
+
+func TestSegmentTrackAcrossChunks(t *testing.T) {
+	h := newTripHandler()
+
+	// Parked, then a slow drive crossing the chunk boundary, then parked
+	points := []TrackPoint{}
+	sec := func(s int) time.Time { return tripBase.Add(time.Duration(s) * time.Second) }
+	for s := 0; s < tripChunkPoints-1000; s++ {
+		points = append(points, TrackPoint{Latitude: 12.9, Longitude: 77.5, Timestamp: sec(s * 10)})
+	}
+	parkedUntil := len(points) - 1
+	for k := 1; k <= 2000; k++ {
+		points = append(points, TrackPoint{Latitude: 12.9, Longitude: 77.5 + float64(k)*0.0001, Timestamp: sec((parkedUntil + k) * 10)})
+	}
+	driveEnd := len(points) - 1
+	parked := points[driveEnd]
+	for k := 1; k <= 60; k++ {
+		p := parked
+		p.Timestamp = sec((driveEnd + k) * 10)
+		points = append(points, p)
+	}
+	now := points[len(points)-1].Timestamp
+
+	loads := 0
+	load := func(from time.Time) ([]TrackPoint, bool, error) {
+		loads++
+		chunk := []TrackPoint{}
+		for _, p := range points {
+			if !p.Timestamp.Before(from) {
+				chunk = append(chunk, p)
+			}
+		}
+		if len(chunk) > tripChunkPoints {
+			return chunk[:tripChunkPoints], true, nil
+		}
+		return chunk, false, nil
+	}
+
+	trips, resumeAt, err := h.segmentTrack(time.Time{}, now, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads < 2 {
+		t.Fatalf("track loaded in %d chunk(s), want the drive split across chunks", loads)
+	}
+
+	whole, _ := h.segmentTrips(points, now)
+	if len(whole) != 1 || whole[0].StartTime.After(points[parkedUntil+10].Timestamp) {
+		t.Fatalf("unchunked segmentation found %+v, want one trip leaving at the end of the parked stretch", whole)
+	}
+	assertTrips(t, trips, whole)
+	if diff := trips[0].DistanceMeters - whole[0].DistanceMeters; diff > 1e-6 || diff < -1e-6 {
+		t.Errorf("chunked distance %v, want %v", trips[0].DistanceMeters, whole[0].DistanceMeters)
+	}
+	if resumeAt.Before(whole[0].EndTime) {
+		t.Errorf("resumeAt = %v, before the end of the completed trip %v", resumeAt, whole[0].EndTime)
+	}
+}
+
+func assertTrips(t *testing.T, got, want []models.Trip) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d trips %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Status != w.Status || !g.StartTime.Equal(w.StartTime) || !g.EndTime.Equal(w.EndTime) {
+			t.Errorf("trip %d: got %s %v-%v, want %s %v-%v", i,
+				g.Status, g.StartTime.Format("15:04:05"), g.EndTime.Format("15:04:05"),
+				w.Status, w.StartTime.Format("15:04:05"), w.EndTime.Format("15:04:05"))
+		}
+		if g.DurationSeconds != int(g.EndTime.Sub(g.StartTime).Seconds()) {
+			t.Errorf("trip %d: duration %d does not match its times", i, g.DurationSeconds)
+		}
+		if g.Status == tripStatusCompleted && g.DistanceMeters <= 0 {
+			t.Errorf("trip %d: completed trip without distance", i)
+		}
+	}
+}
@@ -76,7 +76,7 @@ func (h *Handler) GetVehicles(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
 	rows, err := h.DB.Query(`
-		SELECT id, vehicle_number, driver_name, vehicle_type, phone, status, created_at
+		SELECT id, vehicle_number, driver_name, vehicle_type, phone, status, total_distance_meters, created_at
 		FROM vehicles
 		ORDER BY created_at DESC
 	`)
@@ -89,7 +89,7 @@ func (h *Handler) GetVehicles(w http.ResponseWriter, r *http.Request) {
 	vehicles := []models.Vehicle{}
 	for rows.Next() {
 		var v models.Vehicle
-		err := rows.Scan(&v.ID, &v.VehicleNumber, &v.DriverName, &v.VehicleType, &v.Phone, &v.Status, &v.CreatedAt)
+		err := rows.Scan(&v.ID, &v.VehicleNumber, &v.DriverName, &v.VehicleType, &v.Phone, &v.Status, &v.TotalDistanceMeters, &v.CreatedAt)
 		if err != nil {
 			continue
 		}
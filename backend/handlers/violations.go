@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,6 +9,8 @@ import (
 	"time"
 
 	"geofencing-system/models"
+
+	"github.com/gorilla/mux"
 )
 
 type GetViolationHistoryResponse struct {
@@ -24,6 +27,12 @@ func (h *Handler) GetViolationHistory(w http.ResponseWriter, r *http.Request) {
 	startDate := r.URL.Query().Get("start_date")
 	endDate := r.URL.Query().Get("end_date")
 	limitStr := r.URL.Query().Get("limit")
+	status := r.URL.Query().Get("status")
+
+	if status != "" && !validViolationStatuses[status] {
+		http.Error(w, "Invalid status. Must be one of: open, acknowledged, resolved", http.StatusBadRequest)
+		return
+	}
 
 	limit := 50
 	if limitStr != "" {
@@ -36,11 +45,7 @@ func (h *Handler) GetViolationHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	query := `
-		SELECT v.id, v.vehicle_id, vh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp
-		FROM violations v
-		JOIN vehicles vh ON v.vehicle_id = vh.id
-		JOIN geofences g ON v.geofence_id = g.id
+	query := violationSelect + `
 		WHERE 1=1
 	`
 
@@ -59,6 +64,12 @@ func (h *Handler) GetViolationHistory(w http.ResponseWriter, r *http.Request) {
 		argCount++
 	}
 
+	if status != "" {
+		query += fmt.Sprintf(" AND v.status = $%d", argCount)
+		args = append(args, status)
+		argCount++
+	}
+
 	if startDate != "" {
 		query += fmt.Sprintf(" AND v.timestamp >= $%d", argCount)
 		args = append(args, startDate)
@@ -89,8 +100,7 @@ func (h *Handler) GetViolationHistory(w http.ResponseWriter, r *http.Request) {
 
 	violations := []models.Violation{}
 	for rows.Next() {
-		var v models.Violation
-		err := rows.Scan(&v.ID, &v.VehicleID, &v.VehicleNumber, &v.GeofenceID, &v.GeofenceName, &v.EventType, &v.Latitude, &v.Longitude, &v.Timestamp)
+		v, err := scanViolation(rows)
 		if err != nil {
 			continue
 		}
@@ -108,3 +118,135 @@ func (h *Handler) GetViolationHistory(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+var validViolationStatuses = map[string]bool{
+	models.ViolationStatusOpen:         true,
+	models.ViolationStatusAcknowledged: true,
+	models.ViolationStatusResolved:     true,
+}
+
+const violationSelect = `
+	SELECT v.id, v.vehicle_id, vh.vehicle_number, v.geofence_id, g.name, v.event_type, v.latitude, v.longitude, v.timestamp,
+		v.dwell_seconds, v.speed_kmh, v.speed_limit_kmh,
+		v.status, v.acknowledged_by, v.acknowledged_at, v.acknowledgment_notes, v.resolved_by, v.resolved_at, v.resolution_notes
+	FROM violations v
+	JOIN vehicles vh ON v.vehicle_id = vh.id
+	JOIN geofences g ON v.geofence_id = g.id
+`
+
+func scanViolation(row rowScanner) (models.Violation, error) {
+	var v models.Violation
+	var dwellSeconds sql.NullInt64
+	var speedKmh, speedLimitKmh sql.NullFloat64
+	err := row.Scan(&v.ID, &v.VehicleID, &v.VehicleNumber, &v.GeofenceID, &v.GeofenceName, &v.EventType, &v.Latitude, &v.Longitude, &v.Timestamp,
+		&dwellSeconds, &speedKmh, &speedLimitKmh,
+		&v.Status, &v.AcknowledgedBy, &v.AcknowledgedAt, &v.AcknowledgmentNotes, &v.ResolvedBy, &v.ResolvedAt, &v.ResolutionNotes)
+	if err != nil {
+		return v, err
+	}
+	if dwellSeconds.Valid {
+		n := int(dwellSeconds.Int64)
+		v.DwellSeconds = &n
+	}
+	if speedKmh.Valid {
+		v.SpeedKmh = &speedKmh.Float64
+	}
+	if speedLimitKmh.Valid {
+		v.SpeedLimitKmh = &speedLimitKmh.Float64
+	}
+	return v, nil
+}
+
+type ViolationActionRequest struct {
+	By    string `json:"by"`
+	Notes string `json:"notes"`
+}
+
+type ViolationActionResponse struct {
+	Violation models.Violation `json:"violation"`
+	TimeNs    string           `json:"time_ns"`
+}
+
+// ViolationStatusMessage is broadcast on the alerts WebSocket when a
+// violation is acknowledged or resolved. Alert messages carry no type.
+type ViolationStatusMessage struct {
+	Type      string           `json:"type"`
+	Violation models.Violation `json:"violation"`
+}
+
+// AcknowledgeViolation marks an open violation as being handled.
+func (h *Handler) AcknowledgeViolation(w http.ResponseWriter, r *http.Request) {
+	h.changeViolationStatus(w, r, models.ViolationStatusAcknowledged, `
+		UPDATE violations
+		SET status = 'acknowledged', acknowledged_by = $2, acknowledged_at = $3, acknowledgment_notes = NULLIF($4, '')
+		WHERE id = $1 AND status = 'open'
+	`)
+}
+
+// ResolveViolation closes an open or acknowledged violation.
+func (h *Handler) ResolveViolation(w http.ResponseWriter, r *http.Request) {
+	h.changeViolationStatus(w, r, models.ViolationStatusResolved, `
+		UPDATE violations
+		SET status = 'resolved', resolved_by = $2, resolved_at = $3, resolution_notes = NULLIF($4, '')
+		WHERE id = $1 AND status IN ('open', 'acknowledged')
+	`)
+}
+
+// changeViolationStatus runs update, which moves the violation to status
+// only from an allowed previous status, and broadcasts the result.
+func (h *Handler) changeViolationStatus(w http.ResponseWriter, r *http.Request, status, update string) {
+	start := time.Now()
+
+	id := mux.Vars(r)["id"]
+
+	var req ViolationActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.By == "" {
+		http.Error(w, "by is required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(update, id, req.By, time.Now().UTC(), req.Notes)
+	if err != nil {
+		http.Error(w, "Failed to update violation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var current string
+		err := h.DB.QueryRow(`SELECT status FROM violations WHERE id = $1`, id).Scan(&current)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "violation_not_found", "Violation not found", map[string]string{"violation_id": id})
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch violation", http.StatusInternalServerError)
+			return
+		}
+		writeError(w, http.StatusConflict, "invalid_status_transition",
+			fmt.Sprintf("Violation is %s and cannot be %s", current, status),
+			map[string]string{"violation_id": id, "status": current})
+		return
+	}
+
+	violation, err := scanViolation(h.DB.QueryRow(violationSelect+` WHERE v.id = $1`, id))
+	if err != nil {
+		http.Error(w, "Failed to fetch violation", http.StatusInternalServerError)
+		return
+	}
+
+	message, _ := json.Marshal(ViolationStatusMessage{Type: "violation_status", Violation: violation})
+	h.broadcastAlerts([][]byte{message})
+
+	elapsed := time.Since(start).Nanoseconds()
+
+	response := ViolationActionResponse{
+		Violation: violation,
+		TimeNs:    fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
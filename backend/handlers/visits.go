@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"geofencing-system/models"
+
+	"github.com/google/uuid"
+)
+
+type GetVisitsResponse struct {
+	Visits []models.Visit `json:"visits"`
+	// TotalDurationSeconds is the summed duration of the listed visits
+	// within the requested period.
+	TotalDurationSeconds int    `json:"total_duration_seconds"`
+	TotalCount           int    `json:"total_count"`
+	TimeNs               string `json:"time_ns"`
+}
+
+// openVisit starts a visit on a confirmed entry. An already open visit for
+// the same vehicle and geofence is kept.
+func openVisit(tx dbExecutor, vehicleID, geofenceID string, enteredAt time.Time, lat, lon float64) error {
+	_, err := tx.Exec(`
+		INSERT INTO geofence_visits (id, vehicle_id, geofence_id, entered_at, entry_latitude, entry_longitude)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (vehicle_id, geofence_id) WHERE exited_at IS NULL DO NOTHING
+	`, "visit_"+uuid.New().String()[:8], vehicleID, geofenceID, enteredAt, lat, lon)
+	return err
+}
+
+// closeVisit ends the open visit of the vehicle in the geofence on a
+// confirmed exit, at the first fix outside.
+func closeVisit(tx dbExecutor, vehicleID, geofenceID string, exitedAt time.Time, lat, lon float64) error {
+	_, err := tx.Exec(`
+		UPDATE geofence_visits
+		SET exited_at = $3, exit_latitude = $4, exit_longitude = $5
+		WHERE vehicle_id = $1 AND geofence_id = $2 AND exited_at IS NULL
+	`, vehicleID, geofenceID, exitedAt, lat, lon)
+	return err
+}
+
+// GetVisits lists geofence visits, newest first. start and end select the
+// visits overlapping that period, and durations only count the time within
+// it; open visits last until now.
+func (h *Handler) GetVisits(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+
+	vehicleID := r.URL.Query().Get("vehicle_id")
+	geofenceID := r.URL.Query().Get("geofence_id")
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+	limitStr := r.URL.Query().Get("limit")
+
+	limit := 100
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			if l > 1000 {
+				limit = 1000
+			} else {
+				limit = l
+			}
+		}
+	}
+
+	query := `
+		SELECT v.id, v.vehicle_id, vh.vehicle_number, v.geofence_id, g.name, g.category,
+			v.entered_at, v.exited_at, v.entry_latitude, v.entry_longitude, v.exit_latitude, v.exit_longitude
+		FROM geofence_visits v
+		JOIN vehicles vh ON v.vehicle_id = vh.id
+		JOIN geofences g ON v.geofence_id = g.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+	argCount := 1
+	var rangeStart, rangeEnd time.Time
+
+	if vehicleID != "" {
+		query += fmt.Sprintf(" AND v.vehicle_id = $%d", argCount)
+		args = append(args, vehicleID)
+		argCount++
+	}
+	if geofenceID != "" {
+		query += fmt.Sprintf(" AND v.geofence_id = $%d", argCount)
+		args = append(args, geofenceID)
+		argCount++
+	}
+	if startStr != "" {
+		start, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			http.Error(w, "start must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		rangeStart = start.UTC()
+		query += fmt.Sprintf(" AND (v.exited_at IS NULL OR v.exited_at > $%d)", argCount)
+		args = append(args, rangeStart)
+		argCount++
+	}
+	if endStr != "" {
+		end, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			http.Error(w, "end must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		rangeEnd = end.UTC()
+		query += fmt.Sprintf(" AND v.entered_at < $%d", argCount)
+		args = append(args, rangeEnd)
+		argCount++
+	}
+
+	query += fmt.Sprintf(" ORDER BY v.entered_at DESC LIMIT $%d", argCount)
+	args = append(args, limit)
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch visits", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	visits := []models.Visit{}
+	totalDuration := 0
+	for rows.Next() {
+		var v models.Visit
+		var exitedAt sql.NullTime
+		var entryLat, entryLon, exitLat, exitLon sql.NullFloat64
+		err := rows.Scan(&v.ID, &v.VehicleID, &v.VehicleNumber, &v.GeofenceID, &v.GeofenceName, &v.Category,
+			&v.EnteredAt, &exitedAt, &entryLat, &entryLon, &exitLat, &exitLon)
+		if err != nil {
+			continue
+		}
+
+		from, until := v.EnteredAt, now
+		if exitedAt.Valid {
+			v.ExitedAt = &exitedAt.Time
+			until = exitedAt.Time
+		}
+		// Only the part of the visit within [start, end) counts
+		if !rangeStart.IsZero() && from.Before(rangeStart) {
+			from = rangeStart
+		}
+		if !rangeEnd.IsZero() && until.After(rangeEnd) {
+			until = rangeEnd
+		}
+		if until.After(from) {
+			v.DurationSeconds = int(until.Sub(from).Seconds())
+		}
+		if entryLat.Valid && entryLon.Valid {
+			v.EntryLatitude, v.EntryLongitude = &entryLat.Float64, &entryLon.Float64
+		}
+		if exitLat.Valid && exitLon.Valid {
+			v.ExitLatitude, v.ExitLongitude = &exitLat.Float64, &exitLon.Float64
+		}
+
+		totalDuration += v.DurationSeconds
+		visits = append(visits, v)
+	}
+
+	elapsed := time.Since(begin).Nanoseconds()
+
+	response := GetVisitsResponse{
+		Visits:               visits,
+		TotalDurationSeconds: totalDuration,
+		TotalCount:           len(visits),
+		TimeNs:               fmt.Sprintf("%d", elapsed),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"geofencing-system/handlers"
 	"geofencing-system/models"
@@ -75,19 +76,51 @@ func main() {
 	// Create handlers
 	h := handlers.New(db, hub)
 
+	// Load geofences into the in-memory spatial index
+	if err := h.RefreshGeofenceIndex(); err != nil {
+		log.Fatal("Failed to load geofence index:", err)
+	}
+	log.Printf("Loaded %d geofences into spatial index", h.Index.Len())
+	go h.RunGeofenceIndexRefresher(time.Minute)
+
+	// Start background evaluators
+	go h.RunDwellEvaluator(h.Config.DwellCheckInterval)
+	go h.RunTripDetector(h.Config.TripCheckInterval)
+
 	// Setup router
 	r := mux.NewRouter()
 
 	// API endpoints
 	r.HandleFunc("/geofences", h.CreateGeofence).Methods("POST")
 	r.HandleFunc("/geofences", h.GetGeofences).Methods("GET")
+	r.HandleFunc("/geofences.geojson", h.ExportGeofencesGeoJSON).Methods("GET")
+	r.HandleFunc("/geofences/import", h.ImportGeofencesGeoJSON).Methods("POST")
+	r.HandleFunc("/geofences/{id}", h.GetGeofence).Methods("GET")
+	r.HandleFunc("/geofences/{id}", h.UpdateGeofence).Methods("PUT", "PATCH")
+	r.HandleFunc("/geofences/{id}", h.DeleteGeofence).Methods("DELETE")
 	r.HandleFunc("/vehicles", h.CreateVehicle).Methods("POST")
 	r.HandleFunc("/vehicles", h.GetVehicles).Methods("GET")
 	r.HandleFunc("/vehicles/location", h.UpdateVehicleLocation).Methods("POST")
+	r.HandleFunc("/vehicles/locations/batch", h.UpdateVehicleLocationsBatch).Methods("POST")
 	r.HandleFunc("/vehicles/location/{vehicle_id}", h.GetVehicleLocation).Methods("GET")
+	r.HandleFunc("/vehicles/{id}/track", h.GetVehicleTrack).Methods("GET")
+	r.HandleFunc("/vehicles/{id}/trips", h.GetVehicleTrips).Methods("GET")
+	r.HandleFunc("/vehicles/{id}/trips/rebuild", h.RebuildVehicleTrips).Methods("POST")
+	r.HandleFunc("/vehicles/{id}/stops", h.GetVehicleStops).Methods("GET")
+	r.HandleFunc("/vehicles/{id}/distance", h.GetVehicleDistance).Methods("GET")
 	r.HandleFunc("/alerts/configure", h.ConfigureAlert).Methods("POST")
+	r.HandleFunc("/alerts/configure/batch", h.ConfigureAlertsBatch).Methods("POST")
 	r.HandleFunc("/alerts", h.GetAlerts).Methods("GET")
+	r.HandleFunc("/alerts/{id}", h.UpdateAlert).Methods("PATCH")
+	r.HandleFunc("/alerts/{id}", h.DeleteAlert).Methods("DELETE")
+	r.HandleFunc("/alerts/{id}/pause", h.PauseAlert).Methods("POST")
+	r.HandleFunc("/alerts/{id}/resume", h.ResumeAlert).Methods("POST")
 	r.HandleFunc("/violations/history", h.GetViolationHistory).Methods("GET")
+	r.HandleFunc("/violations/{id}/acknowledge", h.AcknowledgeViolation).Methods("POST")
+	r.HandleFunc("/violations/{id}/resolve", h.ResolveViolation).Methods("POST")
+	r.HandleFunc("/visits", h.GetVisits).Methods("GET")
+	r.HandleFunc("/tolls/charges", h.GetTollCharges).Methods("GET")
+	r.HandleFunc("/tolls/summary", h.GetTollSummary).Methods("GET")
 
 	// WebSocket endpoint
 	r.HandleFunc("/ws/alerts", func(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +130,7 @@ func main() {
 	// CORS configuration
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
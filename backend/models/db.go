@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"log"
 )
 
 func InitDB(db *sql.DB) error {
@@ -19,7 +20,7 @@ func InitDB(db *sql.DB) error {
 			description TEXT,
 			category VARCHAR(50) NOT NULL,
 			coordinates TEXT NOT NULL,
-			geom GEOMETRY(POLYGON, 4326),
+			geom GEOMETRY(MULTIPOLYGON, 4326),
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		);
 	`)
@@ -91,6 +92,404 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
+	// Circular geofences
+	_, err = db.Exec(`
+		ALTER TABLE geofences
+			ADD COLUMN IF NOT EXISTS geofence_type VARCHAR(20) NOT NULL DEFAULT 'polygon',
+			ADD COLUMN IF NOT EXISTS center_latitude DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS center_longitude DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS radius_meters DOUBLE PRECISION;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Polygons with holes and multipolygons
+	_, err = db.Exec(`
+		ALTER TABLE geofences
+			ADD COLUMN IF NOT EXISTS holes TEXT,
+			ADD COLUMN IF NOT EXISTS polygons TEXT;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM geometry_columns
+				WHERE f_table_name = 'geofences' AND f_geometry_column = 'geom' AND type = 'POLYGON'
+			) THEN
+				ALTER TABLE geofences ALTER COLUMN geom TYPE GEOMETRY(MULTIPOLYGON, 4326) USING ST_Multi(geom);
+			END IF;
+		END
+		$$;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create vehicle_geofence_state table holding each vehicle's current memberships
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS vehicle_geofence_state (
+			vehicle_id VARCHAR(50) REFERENCES vehicles(id) ON DELETE CASCADE,
+			geofence_id VARCHAR(50) REFERENCES geofences(id) ON DELETE CASCADE,
+			entered_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (vehicle_id, geofence_id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Seed memberships from each vehicle's latest location when the state table is empty
+	_, err = db.Exec(`
+		INSERT INTO vehicle_geofence_state (vehicle_id, geofence_id, entered_at)
+		SELECT latest.vehicle_id, g.id, latest.timestamp
+		FROM (
+			SELECT DISTINCT ON (vehicle_id) vehicle_id, geom, timestamp
+			FROM vehicle_locations
+			ORDER BY vehicle_id, timestamp DESC, id DESC
+		) latest
+		JOIN geofences g ON ST_Contains(g.geom, latest.geom)
+		WHERE NOT EXISTS (SELECT 1 FROM vehicle_geofence_state)
+		ON CONFLICT DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Event time of the last location evaluated for each vehicle
+	_, err = db.Exec(`ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS last_location_at TIMESTAMP;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE vehicles v
+		SET last_location_at = (SELECT MAX(timestamp) FROM vehicle_locations WHERE vehicle_id = v.id)
+		WHERE v.last_location_at IS NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Entry/exit hysteresis: per-geofence overrides and pending transitions
+	_, err = db.Exec(`
+		ALTER TABLE geofences
+			ADD COLUMN IF NOT EXISTS exit_buffer_meters DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS confirm_fixes INT,
+			ADD COLUMN IF NOT EXISTS min_exit_seconds INT;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE vehicle_geofence_state
+			ADD COLUMN IF NOT EXISTS inside BOOLEAN NOT NULL DEFAULT TRUE,
+			ADD COLUMN IF NOT EXISTS pending_count INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS pending_since TIMESTAMP;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Dwell alerts
+	_, err = db.Exec(`ALTER TABLE alerts ADD COLUMN IF NOT EXISTS dwell_seconds INT;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE violations ADD COLUMN IF NOT EXISTS dwell_seconds INT;`)
+	if err != nil {
+		return err
+	}
+
+	// Create dwell_notifications table so each dwell alert fires once per visit
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS dwell_notifications (
+			alert_id VARCHAR(50) REFERENCES alerts(id) ON DELETE CASCADE,
+			vehicle_id VARCHAR(50) REFERENCES vehicles(id) ON DELETE CASCADE,
+			geofence_id VARCHAR(50) REFERENCES geofences(id) ON DELETE CASCADE,
+			entered_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (alert_id, vehicle_id, geofence_id, entered_at)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Speed-limit zones and speeding violations
+	_, err = db.Exec(`ALTER TABLE geofences ADD COLUMN IF NOT EXISTS speed_limit_kmh DOUBLE PRECISION;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE vehicle_locations ADD COLUMN IF NOT EXISTS speed DOUBLE PRECISION;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE vehicles
+			ADD COLUMN IF NOT EXISTS last_latitude DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS last_longitude DOUBLE PRECISION;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE vehicle_geofence_state ADD COLUMN IF NOT EXISTS speeding BOOLEAN NOT NULL DEFAULT FALSE;`)
+	if err != nil {
+		return err
+	}
+
+	// Extended telemetry on location updates
+	_, err = db.Exec(`
+		ALTER TABLE vehicle_locations
+			ADD COLUMN IF NOT EXISTS heading DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS altitude DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS satellites INTEGER,
+			ADD COLUMN IF NOT EXISTS ignition BOOLEAN,
+			ADD COLUMN IF NOT EXISTS battery_voltage DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS odometer DOUBLE PRECISION;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Bad GPS fixes are kept but flagged
+	_, err = db.Exec(`
+		ALTER TABLE vehicle_locations
+			ADD COLUMN IF NOT EXISTS flagged BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS flag_reason TEXT;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE violations
+			ADD COLUMN IF NOT EXISTS speed_kmh DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS speed_limit_kmh DOUBLE PRECISION;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create trips table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS trips (
+			id VARCHAR(50) PRIMARY KEY,
+			vehicle_id VARCHAR(50) REFERENCES vehicles(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL,
+			start_time TIMESTAMP NOT NULL,
+			end_time TIMESTAMP NOT NULL,
+			start_latitude DOUBLE PRECISION NOT NULL,
+			start_longitude DOUBLE PRECISION NOT NULL,
+			end_latitude DOUBLE PRECISION NOT NULL,
+			end_longitude DOUBLE PRECISION NOT NULL,
+			distance_meters DOUBLE PRECISION NOT NULL,
+			max_speed_kmh DOUBLE PRECISION NOT NULL,
+			geofences TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Where trip detection resumes for each vehicle
+	_, err = db.Exec(`
+		ALTER TABLE vehicles
+			ADD COLUMN IF NOT EXISTS trip_cursor_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS trips_updated_at TIMESTAMP;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Distance traveled, seeded from the stored track
+	_, err = db.Exec(`ALTER TABLE vehicles ADD COLUMN IF NOT EXISTS total_distance_meters DOUBLE PRECISION;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE vehicles v
+		SET total_distance_meters = (
+			SELECT COALESCE(SUM(ST_DistanceSphere(prev_geom, geom)), 0)
+			FROM (
+				SELECT geom, LAG(geom) OVER (ORDER BY timestamp, id) AS prev_geom
+				FROM vehicle_locations
+				WHERE vehicle_id = v.id AND NOT flagged
+			) track
+			WHERE prev_geom IS NOT NULL
+		)
+		WHERE v.total_distance_meters IS NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE vehicles
+			ALTER COLUMN total_distance_meters SET DEFAULT 0,
+			ALTER COLUMN total_distance_meters SET NOT NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Toll zones and their charges
+	_, err = db.Exec(`ALTER TABLE geofences ADD COLUMN IF NOT EXISTS toll_tariff TEXT;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS toll_charges (
+			id VARCHAR(50) PRIMARY KEY,
+			vehicle_id VARCHAR(50) REFERENCES vehicles(id) ON DELETE CASCADE,
+			geofence_id VARCHAR(50) REFERENCES geofences(id) ON DELETE SET NULL,
+			geofence_name VARCHAR(255) NOT NULL,
+			vehicle_type VARCHAR(50) NOT NULL,
+			entered_at TIMESTAMP NOT NULL,
+			exited_at TIMESTAMP NOT NULL,
+			distance_meters DOUBLE PRECISION NOT NULL,
+			mode VARCHAR(20) NOT NULL,
+			rate DOUBLE PRECISION NOT NULL,
+			amount DOUBLE PRECISION NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (vehicle_id, geofence_id, entered_at)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create geofence_visits table pairing each entry with its exit
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS geofence_visits (
+			id VARCHAR(50) PRIMARY KEY,
+			vehicle_id VARCHAR(50) REFERENCES vehicles(id) ON DELETE CASCADE,
+			geofence_id VARCHAR(50) REFERENCES geofences(id) ON DELETE CASCADE,
+			entered_at TIMESTAMP NOT NULL,
+			exited_at TIMESTAMP,
+			entry_latitude DOUBLE PRECISION,
+			entry_longitude DOUBLE PRECISION,
+			exit_latitude DOUBLE PRECISION,
+			exit_longitude DOUBLE PRECISION,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_geofence_visits_open
+		ON geofence_visits(vehicle_id, geofence_id) WHERE exited_at IS NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Position of the first fix of a pending transition, where the visit
+	// starts or ends once the transition is confirmed
+	_, err = db.Exec(`
+		ALTER TABLE vehicle_geofence_state
+			ADD COLUMN IF NOT EXISTS pending_latitude DOUBLE PRECISION,
+			ADD COLUMN IF NOT EXISTS pending_longitude DOUBLE PRECISION;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Open visits for vehicles already inside a geofence
+	_, err = db.Exec(`
+		INSERT INTO geofence_visits (id, vehicle_id, geofence_id, entered_at)
+		SELECT 'visit_' || substr(md5(random()::text), 1, 8), s.vehicle_id, s.geofence_id, s.entered_at
+		FROM vehicle_geofence_state s
+		WHERE s.inside
+		AND NOT EXISTS (
+			SELECT 1 FROM geofence_visits v
+			WHERE v.vehicle_id = s.vehicle_id AND v.geofence_id = s.geofence_id AND v.exited_at IS NULL
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Acknowledge/resolve lifecycle of violations
+	_, err = db.Exec(`
+		ALTER TABLE violations
+			ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'open',
+			ADD COLUMN IF NOT EXISTS acknowledged_by VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS acknowledged_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS acknowledgment_notes TEXT,
+			ADD COLUMN IF NOT EXISTS resolved_by VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS resolution_notes TEXT;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Alert rules may target a geofence category, or all geofences when both
+	// geofence_id and geofence_category are NULL
+	_, err = db.Exec(`ALTER TABLE alerts ADD COLUMN IF NOT EXISTS geofence_category VARCHAR(50);`)
+	if err != nil {
+		return err
+	}
+
+	// Pause duplicate active alert rules, keeping the oldest active, so only
+	// one of them matches events. Paused rules stay out of the unique index.
+	rows, err := db.Query(`
+		UPDATE alerts a
+		SET status = 'paused'
+		FROM alerts b
+		WHERE a.geofence_id IS NOT DISTINCT FROM b.geofence_id
+		AND a.geofence_category IS NOT DISTINCT FROM b.geofence_category
+		AND a.vehicle_id IS NOT DISTINCT FROM b.vehicle_id
+		AND a.event_type = b.event_type
+		AND a.dwell_seconds IS NOT DISTINCT FROM b.dwell_seconds
+		AND a.status = 'active' AND b.status = 'active'
+		AND (b.created_at, b.id) < (a.created_at, a.id)
+		RETURNING a.id;
+	`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		log.Printf("Paused duplicate alert rule %s", id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_unique_active
+		ON alerts(COALESCE(geofence_id, ''), COALESCE(geofence_category, ''), COALESCE(vehicle_id, ''),
+			event_type, COALESCE(dwell_seconds, 0))
+		WHERE status = 'active';
+	`)
+	if err != nil {
+		return err
+	}
+
 	// Create indexes
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_vehicle_locations_vehicle_id ON vehicle_locations(vehicle_id);`)
 	if err != nil {
@@ -102,6 +501,12 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
+	// Track, trip, distance and toll queries scan one vehicle's time range in order
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_vehicle_locations_vehicle_ts ON vehicle_locations(vehicle_id, timestamp);`)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_violations_vehicle_id ON violations(vehicle_id);`)
 	if err != nil {
 		return err
@@ -117,10 +522,30 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_violations_status ON violations(status);`)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_geofences_geom ON geofences USING GIST(geom);`)
 	if err != nil {
 		return err
 	}
 
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_trips_vehicle_start ON trips(vehicle_id, start_time);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_toll_charges_exited_at ON toll_charges(exited_at);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_geofence_visits_entered_at ON geofence_visits(entered_at);`)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
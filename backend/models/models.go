@@ -2,23 +2,85 @@ package models
 
 import "time"
 
+const (
+	GeofenceTypePolygon      = "polygon"
+	GeofenceTypeMultiPolygon = "multipolygon"
+	GeofenceTypeCircle       = "circle"
+)
+
+// GeofenceShape describes the area covered by a geofence. Rings are closed
+// lists of [lat, lon] points.
+//
+//   - polygon: Coordinates is the outer ring and Holes the interior rings.
+//   - multipolygon: each entry of Polygons is a polygon given as its outer
+//     ring followed by its interior rings.
+//   - circle: Center ([lat, lon]) and RadiusMeters.
+type GeofenceShape struct {
+	Type         string           `json:"type"`
+	Coordinates  [][2]float64     `json:"coordinates"`
+	Holes        [][][2]float64   `json:"holes,omitempty"`
+	Polygons     [][][][2]float64 `json:"polygons,omitempty"`
+	Center       *[2]float64      `json:"center,omitempty"`
+	RadiusMeters float64          `json:"radius_meters,omitempty"`
+}
+
+// HysteresisSettings override the global entry/exit hysteresis for one
+// geofence. Unset fields fall back to the server defaults.
+type HysteresisSettings struct {
+	ExitBufferMeters *float64 `json:"exit_buffer_meters,omitempty"`
+	ConfirmFixes     *int     `json:"confirm_fixes,omitempty"`
+	MinExitSeconds   *int     `json:"min_exit_seconds,omitempty"`
+}
+
 type Geofence struct {
-	ID          string       `json:"id"`
-	Name        string       `json:"name"`
-	Description string       `json:"description,omitempty"`
-	Coordinates [][2]float64 `json:"coordinates"`
-	Category    string       `json:"category"`
-	CreatedAt   time.Time    `json:"created_at"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	GeofenceShape
+	Category      string              `json:"category"`
+	Hysteresis    *HysteresisSettings `json:"hysteresis,omitempty"`
+	SpeedLimitKmh *float64            `json:"speed_limit_kmh,omitempty"`
+	Toll          *TollTariff         `json:"toll,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+}
+
+const (
+	TollModePerEntry    = "per_entry"
+	TollModePerDistance = "per_distance"
+)
+
+// TollTariff is what a toll zone charges for one entry/exit pair: a fixed
+// amount per entry or an amount per km driven inside. Rates are keyed by
+// vehicle type; DefaultRate applies to the other types.
+type TollTariff struct {
+	Mode        string             `json:"mode"`
+	Currency    string             `json:"currency"`
+	Rates       map[string]float64 `json:"rates,omitempty"`
+	DefaultRate *float64           `json:"default_rate,omitempty"`
 }
 
 type Vehicle struct {
-	ID            string    `json:"id"`
-	VehicleNumber string    `json:"vehicle_number"`
-	DriverName    string    `json:"driver_name"`
-	VehicleType   string    `json:"vehicle_type"`
-	Phone         string    `json:"phone"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            string `json:"id"`
+	VehicleNumber string `json:"vehicle_number"`
+	DriverName    string `json:"driver_name"`
+	VehicleType   string `json:"vehicle_type"`
+	Phone         string `json:"phone"`
+	Status        string `json:"status"`
+	// TotalDistanceMeters is the geodesic length of the vehicle's track.
+	TotalDistanceMeters float64   `json:"total_distance_meters"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// Telemetry holds the optional device readings sent with a location.
+type Telemetry struct {
+	Speed          *float64 `json:"speed,omitempty"`           // km/h
+	Heading        *float64 `json:"heading,omitempty"`         // degrees clockwise from north
+	Altitude       *float64 `json:"altitude,omitempty"`        // meters above sea level
+	Accuracy       *float64 `json:"accuracy,omitempty"`        // horizontal accuracy in meters
+	Satellites     *int     `json:"satellites,omitempty"`      // satellites in view
+	Ignition       *bool    `json:"ignition,omitempty"`        // engine ignition on
+	BatteryVoltage *float64 `json:"battery_voltage,omitempty"` // volts
+	Odometer       *float64 `json:"odometer,omitempty"`        // km
 }
 
 type VehicleLocation struct {
@@ -27,18 +89,10 @@ type VehicleLocation struct {
 	Latitude  float64   `json:"latitude"`
 	Longitude float64   `json:"longitude"`
 	Timestamp time.Time `json:"timestamp"`
+	Telemetry
 	CreatedAt time.Time `json:"created_at"`
 }
 
-type Alert struct {
-	ID         string    `json:"alert_id"`
-	GeofenceID string    `json:"geofence_id"`
-	VehicleID  *string   `json:"vehicle_id,omitempty"`
-	EventType  string    `json:"event_type"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
-}
-
 type Violation struct {
 	ID            string    `json:"id"`
 	VehicleID     string    `json:"vehicle_id"`
@@ -49,6 +103,79 @@ type Violation struct {
 	Latitude      float64   `json:"latitude"`
 	Longitude     float64   `json:"longitude"`
 	Timestamp     time.Time `json:"timestamp"`
+	DwellSeconds  *int      `json:"dwell_seconds,omitempty"`
+	SpeedKmh      *float64  `json:"speed_kmh,omitempty"`
+	SpeedLimitKmh *float64  `json:"speed_limit_kmh,omitempty"`
+	// Handling lifecycle: open, then acknowledged and/or resolved.
+	Status              string     `json:"status"`
+	AcknowledgedBy      *string    `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt      *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgmentNotes *string    `json:"acknowledgment_notes,omitempty"`
+	ResolvedBy          *string    `json:"resolved_by,omitempty"`
+	ResolvedAt          *time.Time `json:"resolved_at,omitempty"`
+	ResolutionNotes     *string    `json:"resolution_notes,omitempty"`
+}
+
+const (
+	ViolationStatusOpen         = "open"
+	ViolationStatusAcknowledged = "acknowledged"
+	ViolationStatusResolved     = "resolved"
+)
+
+// Trip is a stretch of movement between two stops of a vehicle.
+type Trip struct {
+	ID              string         `json:"id"`
+	VehicleID       string         `json:"vehicle_id"`
+	Status          string         `json:"status"`
+	StartTime       time.Time      `json:"start_time"`
+	EndTime         time.Time      `json:"end_time"`
+	StartLatitude   float64        `json:"start_latitude"`
+	StartLongitude  float64        `json:"start_longitude"`
+	EndLatitude     float64        `json:"end_latitude"`
+	EndLongitude    float64        `json:"end_longitude"`
+	DistanceMeters  float64        `json:"distance_meters"`
+	DurationSeconds int            `json:"duration_seconds"`
+	MaxSpeedKmh     float64        `json:"max_speed_kmh"`
+	Geofences       []TripGeofence `json:"geofences_visited"`
+}
+
+type TripGeofence struct {
+	GeofenceID   string `json:"geofence_id"`
+	GeofenceName string `json:"geofence_name"`
+}
+
+// Visit is one stay of a vehicle inside a geofence, from the confirmed
+// entry to the confirmed exit. ExitedAt is nil while the vehicle is inside.
+type Visit struct {
+	ID              string     `json:"id"`
+	VehicleID       string     `json:"vehicle_id"`
+	VehicleNumber   string     `json:"vehicle_number"`
+	GeofenceID      string     `json:"geofence_id"`
+	GeofenceName    string     `json:"geofence_name"`
+	Category        string     `json:"category"`
+	EnteredAt       time.Time  `json:"entered_at"`
+	ExitedAt        *time.Time `json:"exited_at,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"`
+	EntryLatitude   *float64   `json:"entry_latitude,omitempty"`
+	EntryLongitude  *float64   `json:"entry_longitude,omitempty"`
+	ExitLatitude    *float64   `json:"exit_latitude,omitempty"`
+	ExitLongitude   *float64   `json:"exit_longitude,omitempty"`
+}
+
+type TollCharge struct {
+	ID             string    `json:"id"`
+	VehicleID      string    `json:"vehicle_id"`
+	VehicleNumber  string    `json:"vehicle_number"`
+	VehicleType    string    `json:"vehicle_type"`
+	GeofenceID     string    `json:"geofence_id"`
+	GeofenceName   string    `json:"geofence_name"`
+	EnteredAt      time.Time `json:"entered_at"`
+	ExitedAt       time.Time `json:"exited_at"`
+	DistanceMeters float64   `json:"distance_meters"`
+	Mode           string    `json:"mode"`
+	Rate           float64   `json:"rate"`
+	Amount         float64   `json:"amount"`
+	Currency       string    `json:"currency"`
 }
 
 type GeofenceStatus struct {
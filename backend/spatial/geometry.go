@@ -0,0 +1,174 @@
+package spatial
+
+import (
+	"math"
+
+	"geofencing-system/models"
+)
+
+const earthRadiusMeters = 6371008.8
+
+// metersPerDegreeLat is the approximate length of one degree of latitude.
+const metersPerDegreeLat = 111320.0
+
+// DistanceMeters returns the great-circle (haversine) distance between two
+// points given in degrees.
+func DistanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// PointInRing reports whether the point lies inside a closed [lat, lon] ring
+// using the even-odd ray casting rule.
+func PointInRing(lat, lon float64, ring [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		yi, xi := ring[i][0], ring[i][1]
+		yj, xj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// PointInPolygon reports whether the point lies inside the outer ring
+// (rings[0]) and outside every hole (rings[1:]).
+func PointInPolygon(lat, lon float64, rings [][][2]float64) bool {
+	if len(rings) == 0 || !PointInRing(lat, lon, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if PointInRing(lat, lon, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+// ShapeContains reports whether the point lies inside the geofence shape.
+func ShapeContains(shape models.GeofenceShape, lat, lon float64) bool {
+	switch shape.Type {
+	case models.GeofenceTypeCircle:
+		if shape.Center == nil {
+			return false
+		}
+		return DistanceMeters(lat, lon, shape.Center[0], shape.Center[1]) <= shape.RadiusMeters
+	case models.GeofenceTypeMultiPolygon:
+		for _, polygon := range shape.Polygons {
+			if PointInPolygon(lat, lon, polygon) {
+				return true
+			}
+		}
+		return false
+	default:
+		return PointInPolygon(lat, lon, append([][][2]float64{shape.Coordinates}, shape.Holes...))
+	}
+}
+
+// Rect is a bounding box in degrees.
+type Rect struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+func (r Rect) ContainsPoint(lat, lon float64) bool {
+	return lat >= r.MinLat && lat <= r.MaxLat && lon >= r.MinLon && lon <= r.MaxLon
+}
+
+func (r Rect) Union(o Rect) Rect {
+	return Rect{
+		MinLat: math.Min(r.MinLat, o.MinLat),
+		MinLon: math.Min(r.MinLon, o.MinLon),
+		MaxLat: math.Max(r.MaxLat, o.MaxLat),
+		MaxLon: math.Max(r.MaxLon, o.MaxLon),
+	}
+}
+
+func (r Rect) center() (float64, float64) {
+	return (r.MinLat + r.MaxLat) / 2, (r.MinLon + r.MaxLon) / 2
+}
+
+// ShapeBounds returns the bounding box of a geofence shape.
+func ShapeBounds(shape models.GeofenceShape) Rect {
+	if shape.Type == models.GeofenceTypeCircle && shape.Center != nil {
+		dLat := shape.RadiusMeters / metersPerDegreeLat
+		dLon := dLat / math.Max(math.Cos(shape.Center[0]*math.Pi/180), 1e-6)
+		return Rect{
+			MinLat: shape.Center[0] - dLat,
+			MinLon: shape.Center[1] - dLon,
+			MaxLat: shape.Center[0] + dLat,
+			MaxLon: shape.Center[1] + dLon,
+		}
+	}
+
+	bounds := Rect{MinLat: math.Inf(1), MinLon: math.Inf(1), MaxLat: math.Inf(-1), MaxLon: math.Inf(-1)}
+	extend := func(ring [][2]float64) {
+		for _, p := range ring {
+			bounds = bounds.Union(Rect{MinLat: p[0], MinLon: p[1], MaxLat: p[0], MaxLon: p[1]})
+		}
+	}
+	if shape.Type == models.GeofenceTypeMultiPolygon {
+		for _, polygon := range shape.Polygons {
+			if len(polygon) > 0 {
+				extend(polygon[0])
+			}
+		}
+	} else {
+		extend(shape.Coordinates)
+	}
+	return bounds
+}
+
+// DistanceToShapeMeters returns 0 when the point lies inside the shape and
+// otherwise the approximate distance in meters to the nearest boundary.
+func DistanceToShapeMeters(shape models.GeofenceShape, lat, lon float64) float64 {
+	if ShapeContains(shape, lat, lon) {
+		return 0
+	}
+
+	if shape.Type == models.GeofenceTypeCircle {
+		if shape.Center == nil {
+			return math.Inf(1)
+		}
+		return DistanceMeters(lat, lon, shape.Center[0], shape.Center[1]) - shape.RadiusMeters
+	}
+
+	rings := [][][2]float64{shape.Coordinates}
+	if shape.Type == models.GeofenceTypeMultiPolygon {
+		rings = nil
+		for _, polygon := range shape.Polygons {
+			rings = append(rings, polygon...)
+		}
+	} else {
+		rings = append(rings, shape.Holes...)
+	}
+
+	best := math.Inf(1)
+	for _, ring := range rings {
+		for i := 1; i < len(ring); i++ {
+			best = math.Min(best, distanceToSegmentMeters(lat, lon, ring[i-1], ring[i]))
+		}
+	}
+	return best
+}
+
+// distanceToSegmentMeters projects the segment onto a local equirectangular
+// plane centered on the point and returns the distance to it.
+func distanceToSegmentMeters(lat, lon float64, a, b [2]float64) float64 {
+	cosLat := math.Cos(lat * math.Pi / 180)
+	ax, ay := (a[1]-lon)*cosLat*metersPerDegreeLat, (a[0]-lat)*metersPerDegreeLat
+	bx, by := (b[1]-lon)*cosLat*metersPerDegreeLat, (b[0]-lat)*metersPerDegreeLat
+
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/lengthSq))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
@@ -0,0 +1,88 @@
+package spatial
+
+import (
+	"sort"
+	"sync"
+
+	"geofencing-system/models"
+)
+
+// Entry is an indexed geofence.
+type Entry struct {
+	models.Geofence
+	Bounds Rect
+}
+
+// Contains reports whether the point lies inside the geofence.
+func (e *Entry) Contains(lat, lon float64) bool {
+	return ShapeContains(e.GeofenceShape, lat, lon)
+}
+
+// Within reports whether the point lies inside the geofence or no further
+// than meters from its boundary.
+func (e *Entry) Within(lat, lon, meters float64) bool {
+	if e.Contains(lat, lon) {
+		return true
+	}
+	return meters > 0 && DistanceToShapeMeters(e.GeofenceShape, lat, lon) <= meters
+}
+
+// Index is an in-memory R-tree over geofence bounding boxes with an exact
+// point-in-shape test. It is safe for concurrent use; Load swaps the whole
+// tree at once.
+type Index struct {
+	mu   sync.RWMutex
+	root *node
+	byID map[string]*Entry
+}
+
+func NewIndex() *Index {
+	return &Index{}
+}
+
+// Load replaces the indexed geofences.
+func (idx *Index) Load(geofences []models.Geofence) {
+	entries := make([]*Entry, 0, len(geofences))
+	byID := make(map[string]*Entry, len(geofences))
+	for _, g := range geofences {
+		e := &Entry{Geofence: g, Bounds: ShapeBounds(g.GeofenceShape)}
+		entries = append(entries, e)
+		byID[g.ID] = e
+	}
+	root := buildRTree(entries)
+
+	idx.mu.Lock()
+	idx.root = root
+	idx.byID = byID
+	idx.mu.Unlock()
+}
+
+// Len returns the number of indexed geofences.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.byID)
+}
+
+// Get returns the indexed geofence with the given ID, or nil.
+func (idx *Index) Get(id string) *Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.byID[id]
+}
+
+// Search returns the geofences containing the point, ordered by ID.
+func (idx *Index) Search(lat, lon float64) []*Entry {
+	idx.mu.RLock()
+	root := idx.root
+	idx.mu.RUnlock()
+
+	if root == nil {
+		return nil
+	}
+	results := root.search(lat, lon, nil)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
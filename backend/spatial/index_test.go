@@ -0,0 +1,268 @@
+package spatial
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"geofencing-system/models"
+)
+
+func squareRing(minLat, minLon, size float64) [][2]float64 {
+	return [][2]float64{
+		{minLat, minLon}, {minLat, minLon + size}, {minLat + size, minLon + size},
+		{minLat + size, minLon}, {minLat, minLon},
+	}
+}
+
+func polygon(id string, ring [][2]float64, holes ...[][2]float64) models.Geofence {
+	return models.Geofence{ID: id, GeofenceShape: models.GeofenceShape{
+		Type:        models.GeofenceTypePolygon,
+		Coordinates: ring,
+		Holes:       holes,
+	}}
+}
+
+// randomGeofences scatters n squares and circles of up to ~2 km over a
+// 1x1 degree area.
+func randomGeofences(n int, rng *rand.Rand) []models.Geofence {
+	geofences := make([]models.Geofence, n)
+	for i := range geofences {
+		lat, lon := 12+rng.Float64(), 77+rng.Float64()
+		id := fmt.Sprintf("geo_%05d", i)
+		if i%4 == 0 {
+			center := [2]float64{lat, lon}
+			geofences[i] = models.Geofence{ID: id, GeofenceShape: models.GeofenceShape{
+				Type:         models.GeofenceTypeCircle,
+				Center:       &center,
+				RadiusMeters: 100 + rng.Float64()*1000,
+			}}
+			continue
+		}
+		geofences[i] = polygon(id, squareRing(lat, lon, 0.002+rng.Float64()*0.02))
+	}
+	return geofences
+}
+
+// linearSearch is the reference: every geofence tested exactly, by ID.
+func linearSearch(geofences []models.Geofence, lat, lon float64) []string {
+	ids := []string{}
+	for _, g := range geofences {
+		if ShapeContains(g.GeofenceShape, lat, lon) {
+			ids = append(ids, g.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func searchIDs(idx *Index, lat, lon float64) []string {
+	ids := []string{}
+	for _, e := range idx.Search(lat, lon) {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestPointInPolygonWithHole(t *testing.T) {
+	rings := [][][2]float64{squareRing(0, 0, 10), squareRing(4, 4, 2)}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"inside outer ring", 1, 1, true},
+		{"inside hole", 5, 5, false},
+		{"between hole and edge", 7, 5, true},
+		{"outside", 11, 5, false},
+		{"left of ring", 5, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PointInPolygon(tt.lat, tt.lon, rings); got != tt.want {
+				t.Errorf("PointInPolygon(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointInRingConcave(t *testing.T) {
+	// U shape opening north
+	ring := [][2]float64{{0, 0}, {0, 3}, {3, 3}, {3, 2}, {1, 2}, {1, 1}, {3, 1}, {3, 0}, {0, 0}}
+
+	if !PointInRing(0.5, 1.5, ring) {
+		t.Error("point in the base of the U should be inside")
+	}
+	if !PointInRing(2, 0.5, ring) {
+		t.Error("point in the left arm should be inside")
+	}
+	if PointInRing(2, 1.5, ring) {
+		t.Error("point in the notch should be outside")
+	}
+}
+
+func TestShapeContains(t *testing.T) {
+	center := [2]float64{12.9, 77.5}
+	circle := models.GeofenceShape{Type: models.GeofenceTypeCircle, Center: &center, RadiusMeters: 500}
+	multi := models.GeofenceShape{
+		Type: models.GeofenceTypeMultiPolygon,
+		Polygons: [][][][2]float64{
+			{squareRing(0, 0, 1)},
+			{squareRing(5, 5, 2), squareRing(5.5, 5.5, 1)},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		shape    models.GeofenceShape
+		lat, lon float64
+		want     bool
+	}{
+		{"circle center", circle, 12.9, 77.5, true},
+		{"circle inside radius", circle, 12.9 + 400/metersPerDegreeLat, 77.5, true},
+		{"circle outside radius", circle, 12.9 + 600/metersPerDegreeLat, 77.5, false},
+		{"circle without center", models.GeofenceShape{Type: models.GeofenceTypeCircle, RadiusMeters: 500}, 0, 0, false},
+		{"multipolygon first part", multi, 0.5, 0.5, true},
+		{"multipolygon second part", multi, 5.2, 5.2, true},
+		{"multipolygon hole of second part", multi, 6, 6, false},
+		{"multipolygon between parts", multi, 3, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShapeContains(tt.shape, tt.lat, tt.lon); got != tt.want {
+				t.Errorf("ShapeContains(%v, %v) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSearchMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Enough entries for a three-level tree
+	geofences := randomGeofences(nodeCapacity*nodeCapacity*2, rng)
+
+	// Holes and a multipolygon overlapping the random shapes
+	geofences = append(geofences,
+		polygon("geo_holed", squareRing(12.2, 77.2, 0.3), squareRing(12.3, 77.3, 0.1)),
+		models.Geofence{ID: "geo_multi", GeofenceShape: models.GeofenceShape{
+			Type: models.GeofenceTypeMultiPolygon,
+			Polygons: [][][][2]float64{
+				{squareRing(12.6, 77.6, 0.1)},
+				{squareRing(12.8, 77.1, 0.1), squareRing(12.82, 77.12, 0.05)},
+			},
+		}},
+	)
+
+	idx := NewIndex()
+	idx.Load(geofences)
+	if idx.Len() != len(geofences) {
+		t.Fatalf("Len() = %d, want %d", idx.Len(), len(geofences))
+	}
+
+	for i := 0; i < 5000; i++ {
+		lat, lon := 11.9+rng.Float64()*1.2, 76.9+rng.Float64()*1.2
+		got := searchIDs(idx, lat, lon)
+		want := linearSearch(geofences, lat, lon)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Search(%v, %v) = %v, want %v", lat, lon, got, want)
+		}
+	}
+
+	checks := []struct {
+		name     string
+		lat, lon float64
+		id       string
+		want     bool
+	}{
+		{"holed polygon ring", 12.25, 77.25, "geo_holed", true},
+		{"holed polygon hole", 12.35, 77.35, "geo_holed", false},
+		{"multipolygon first part", 12.65, 77.65, "geo_multi", true},
+		{"multipolygon second part", 12.81, 77.11, "geo_multi", true},
+		{"multipolygon hole", 12.84, 77.14, "geo_multi", false},
+	}
+	for _, c := range checks {
+		found := false
+		for _, id := range searchIDs(idx, c.lat, c.lon) {
+			found = found || id == c.id
+		}
+		if found != c.want {
+			t.Errorf("%s: %s found = %v, want %v", c.name, c.id, found, c.want)
+		}
+	}
+}
+
+func TestStrPackCoversChildren(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	geofences := randomGeofences(nodeCapacity*10+3, rng)
+	entries := make([]*Entry, len(geofences))
+	for i, g := range geofences {
+		entries[i] = &Entry{Geofence: g, Bounds: ShapeBounds(g.GeofenceShape)}
+	}
+
+	root := buildRTree(entries)
+	leaves := 0
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.entry != nil {
+			leaves++
+			return
+		}
+		if len(n.children) == 0 || len(n.children) > nodeCapacity {
+			t.Fatalf("node has %d children, want 1..%d", len(n.children), nodeCapacity)
+		}
+		for _, c := range n.children {
+			if n.bounds.Union(c.bounds) != n.bounds {
+				t.Fatalf("child bounds %v not covered by parent %v", c.bounds, n.bounds)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+
+	if leaves != len(entries) {
+		t.Fatalf("tree holds %d entries, want %d", leaves, len(entries))
+	}
+	if buildRTree(nil) != nil {
+		t.Fatal("empty tree should have no root")
+	}
+}
+
+func TestEmptyIndexSearch(t *testing.T) {
+	if got := NewIndex().Search(12.9, 77.5); len(got) != 0 {
+		t.Fatalf("empty index returned %v", got)
+	}
+}
+
+func benchmarkPoints(rng *rand.Rand) [][2]float64 {
+	points := make([][2]float64, 1024)
+	for i := range points {
+		points[i] = [2]float64{12 + rng.Float64(), 77 + rng.Float64()}
+	}
+	return points
+}
+
+func BenchmarkIndexSearch(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		rng := rand.New(rand.NewSource(1))
+		geofences := randomGeofences(n, rng)
+		points := benchmarkPoints(rng)
+
+		idx := NewIndex()
+		idx.Load(geofences)
+
+		b.Run(fmt.Sprintf("rtree/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				idx.Search(p[0], p[1])
+			}
+		})
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				p := points[i%len(points)]
+				linearSearch(geofences, p[0], p[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package spatial
+
+import (
+	"math"
+	"sort"
+)
+
+// nodeCapacity is the maximum number of children per R-tree node.
+const nodeCapacity = 16
+
+// node is an R-tree node. Leaf items carry an entry and no children.
+type node struct {
+	bounds   Rect
+	children []*node
+	entry    *Entry
+}
+
+// buildRTree bulk-loads a static R-tree with the Sort-Tile-Recursive
+// algorithm and returns its root, or nil when there are no entries.
+func buildRTree(entries []*Entry) *node {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	items := make([]*node, len(entries))
+	for i, e := range entries {
+		items[i] = &node{bounds: e.Bounds, entry: e}
+	}
+
+	for len(items) > nodeCapacity {
+		items = strPack(items)
+	}
+
+	return newParent(items)
+}
+
+// strPack groups items into parent nodes of at most nodeCapacity children,
+// tiling them into vertical slices by longitude and then by latitude.
+func strPack(items []*node) []*node {
+	leafCount := int(math.Ceil(float64(len(items)) / nodeCapacity))
+	sliceCount := int(math.Ceil(math.Sqrt(float64(leafCount))))
+	sliceSize := sliceCount * nodeCapacity
+
+	sort.Slice(items, func(i, j int) bool {
+		_, lonI := items[i].bounds.center()
+		_, lonJ := items[j].bounds.center()
+		return lonI < lonJ
+	})
+
+	parents := []*node{}
+	for s := 0; s < len(items); s += sliceSize {
+		slice := items[s:minInt(s+sliceSize, len(items))]
+		sort.Slice(slice, func(i, j int) bool {
+			latI, _ := slice[i].bounds.center()
+			latJ, _ := slice[j].bounds.center()
+			return latI < latJ
+		})
+		for c := 0; c < len(slice); c += nodeCapacity {
+			parents = append(parents, newParent(slice[c:minInt(c+nodeCapacity, len(slice))]))
+		}
+	}
+	return parents
+}
+
+func newParent(children []*node) *node {
+	n := &node{children: append([]*node(nil), children...), bounds: children[0].bounds}
+	for _, c := range children[1:] {
+		n.bounds = n.bounds.Union(c.bounds)
+	}
+	return n
+}
+
+// search appends to out every entry whose bounds contain the point and
+// whose shape passes the exact containment test.
+func (n *node) search(lat, lon float64, out []*Entry) []*Entry {
+	if !n.bounds.ContainsPoint(lat, lon) {
+		return out
+	}
+	if n.entry != nil {
+		if n.entry.Contains(lat, lon) {
+			out = append(out, n.entry)
+		}
+		return out
+	}
+	for _, c := range n.children {
+		out = c.search(lat, lon, out)
+	}
+	return out
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
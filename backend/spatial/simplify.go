@@ -0,0 +1,48 @@
+package spatial
+
+// SimplifyTrack reduces a [lat, lon] polyline with the Douglas-Peucker
+// algorithm and returns the indexes of the points to keep, in order. Points
+// closer than toleranceMeters to the simplified line are dropped; the first
+// and last points are always kept.
+func SimplifyTrack(points [][2]float64, toleranceMeters float64) []int {
+	n := len(points)
+	if n <= 2 || toleranceMeters <= 0 {
+		kept := make([]int, n)
+		for i := range kept {
+			kept[i] = i
+		}
+		return kept
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+
+	// Iterative to avoid deep recursion on long tracks
+	type span struct{ first, last int }
+	stack := []span{{0, n - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDistance := -1, toleranceMeters
+		for i := s.first + 1; i < s.last; i++ {
+			d := distanceToSegmentMeters(points[i][0], points[i][1], points[s.first], points[s.last])
+			if d > maxDistance {
+				farthest, maxDistance = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+	}
+
+	kept := []int{}
+	for i, k := range keep {
+		if k {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
@@ -13,7 +13,7 @@ import { useWebSocket } from './hooks/useWebSocket';
 
 function App() {
   const [alertCount, setAlertCount] = useState(0);
-  const { lastAlert } = useWebSocket();
+  const { lastAlert, lastStatusChange } = useWebSocket();
 
   useEffect(() => {
     if (lastAlert) {
@@ -43,7 +43,7 @@ function App() {
             <Route path="/geofences" element={<Geofences />} />
             <Route path="/vehicles" element={<Vehicles />} />
             <Route path="/alerts" element={<Alerts />} />
-            <Route path="/history" element={<History />} />
+            <Route path="/history" element={<History lastStatusChange={lastStatusChange} />} />
           </Routes>
         </main>
 
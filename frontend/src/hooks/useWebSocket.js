@@ -5,6 +5,7 @@ const WS_URL = 'wss://mapup-project.onrender.com/ws/alerts';
 
 export const useWebSocket = () => {
   const [lastAlert, setLastAlert] = useState(null);
+  const [lastStatusChange, setLastStatusChange] = useState(null);
   const [isConnected, setIsConnected] = useState(false);
   const ws = useRef(null);
   const reconnectTimeout = useRef(null);
@@ -22,6 +23,13 @@ export const useWebSocket = () => {
       ws.current.onmessage = (event) => {
         try {
           const alert = JSON.parse(event.data);
+
+          // Violation acknowledged/resolved elsewhere; no toast
+          if (alert.type === 'violation_status') {
+            setLastStatusChange(alert.violation);
+            return;
+          }
+
           setLastAlert(alert);
           
           // Show toast notification
@@ -70,5 +78,5 @@ export const useWebSocket = () => {
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, []);
 
-  return { lastAlert, isConnected };
+  return { lastAlert, lastStatusChange, isConnected };
 };
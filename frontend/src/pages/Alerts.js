@@ -1,6 +1,15 @@
 import React, { useState, useEffect } from 'react';
 import { toast } from 'react-toastify';
-import { getAlerts, configureAlert, getGeofences, getVehicles } from '../services/api';
+import {
+  getAlerts,
+  configureAlert,
+  updateAlert,
+  pauseAlert,
+  resumeAlert,
+  deleteAlert,
+  getGeofences,
+  getVehicles,
+} from '../services/api';
 
 const Alerts = () => {
   const [alerts, setAlerts] = useState([]);
@@ -40,10 +49,16 @@ const Alerts = () => {
   const handleSubmit = async (e) => {
     e.preventDefault();
     try {
-      const data = {
-        geofence_id: formData.geofence_id,
-        event_type: formData.event_type,
-      };
+      const data = { event_type: formData.event_type };
+
+      // The geofence select also offers whole categories and all geofences
+      if (formData.geofence_id === 'all') {
+        data.target = 'all';
+      } else if (formData.geofence_id.startsWith('category:')) {
+        data.geofence_category = formData.geofence_id.slice('category:'.length);
+      } else {
+        data.geofence_id = formData.geofence_id;
+      }
       
       if (formData.vehicle_id) {
         data.vehicle_id = formData.vehicle_id;
@@ -55,7 +70,23 @@ const Alerts = () => {
       setFormData({ geofence_id: '', vehicle_id: '', event_type: 'entry' });
       loadData();
     } catch (error) {
-      toast.error(error.response?.data || 'Failed to configure alert');
+      toast.error(error.response?.data?.message || error.response?.data || 'Failed to configure alert');
+    }
+  };
+
+  const handleAction = async (action, successMessage) => {
+    try {
+      await action();
+      toast.success(successMessage);
+      loadData();
+    } catch (error) {
+      toast.error(error.response?.data?.message || error.response?.data || 'Failed to update alert');
+    }
+  };
+
+  const handleDelete = (alert) => {
+    if (window.confirm('Delete this alert rule?')) {
+      handleAction(() => deleteAlert(alert.alert_id), 'Alert deleted');
     }
   };
 
@@ -90,23 +121,44 @@ const Alerts = () => {
                 <th>Event Type</th>
                 <th>Status</th>
                 <th>Created</th>
+                <th>Actions</th>
               </tr>
             </thead>
             <tbody>
               {alerts.map((alert) => (
                 <tr key={alert.alert_id}>
-                  <td>{alert.geofence_name}</td>
+                  <td>
+                    {alert.target === 'all'
+                      ? 'All Geofences'
+                      : alert.target === 'category'
+                        ? `All ${alert.geofence_category}`
+                        : alert.geofence_name}
+                  </td>
                   <td>{alert.vehicle_number || 'All Vehicles'}</td>
                   <td>
-                    <span style={{
-                      padding: '0.3rem 0.8rem',
-                      borderRadius: '12px',
-                      background: alert.event_type === 'entry' ? '#48bb7820' : alert.event_type === 'exit' ? '#f5656520' : '#ed893620',
-                      color: alert.event_type === 'entry' ? '#48bb78' : alert.event_type === 'exit' ? '#f56565' : '#ed8936',
-                      fontSize: '0.85rem',
-                    }}>
-                      {alert.event_type}
-                    </span>
+                    {['entry', 'exit', 'both'].includes(alert.event_type) ? (
+                      <select
+                        value={alert.event_type}
+                        onChange={(e) => handleAction(
+                          () => updateAlert(alert.alert_id, { event_type: e.target.value }),
+                          'Alert updated',
+                        )}
+                      >
+                        <option value="entry">Entry</option>
+                        <option value="exit">Exit</option>
+                        <option value="both">Both (Entry & Exit)</option>
+                      </select>
+                    ) : (
+                      <span style={{
+                        padding: '0.3rem 0.8rem',
+                        borderRadius: '12px',
+                        background: '#ed893620',
+                        color: '#ed8936',
+                        fontSize: '0.85rem',
+                      }}>
+                        {alert.event_type}
+                      </span>
+                    )}
                   </td>
                   <td>
                     <span style={{
@@ -120,6 +172,26 @@ const Alerts = () => {
                     </span>
                   </td>
                   <td>{new Date(alert.created_at).toLocaleString()}</td>
+                  <td>
+                    {alert.status === 'active' ? (
+                      <button
+                        className="btn btn-secondary"
+                        onClick={() => handleAction(() => pauseAlert(alert.alert_id), 'Alert paused')}
+                      >
+                        Pause
+                      </button>
+                    ) : (
+                      <button
+                        className="btn btn-primary"
+                        onClick={() => handleAction(() => resumeAlert(alert.alert_id), 'Alert resumed')}
+                      >
+                        Resume
+                      </button>
+                    )}
+                    <button className="btn btn-danger" onClick={() => handleDelete(alert)}>
+                      Delete
+                    </button>
+                  </td>
                 </tr>
               ))}
             </tbody>
@@ -143,6 +215,12 @@ const Alerts = () => {
                   required
                 >
                   <option value="">Select a geofence</option>
+                  <option value="all">All Geofences</option>
+                  {[...new Set(geofences.map((geo) => geo.category))].map((category) => (
+                    <option key={category} value={`category:${category}`}>
+                      All {category}
+                    </option>
+                  ))}
                   {geofences.map((geo) => (
                     <option key={geo.id} value={geo.id}>
                       {geo.name} ({geo.category})
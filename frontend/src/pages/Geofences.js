@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { MapContainer, TileLayer, Polygon, Popup } from 'react-leaflet';
+import { MapContainer, TileLayer, Polygon, Circle, Popup } from 'react-leaflet';
 import { toast } from 'react-toastify';
 import { getGeofences, createGeofence } from '../services/api';
 
@@ -114,19 +114,33 @@ const Geofences = () => {
                   url="https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png"
                   attribution='&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a>'
                 />
-                {geofences.map((geo) => (
-                  <Polygon
-                    key={geo.id}
-                    positions={geo.coordinates.map(c => [c[0], c[1]])}
-                    pathOptions={{ color: getCategoryColor(geo.category) }}
-                  >
+                {geofences.map((geo) => {
+                  const popup = (
                     <Popup>
                       <strong>{geo.name}</strong><br />
                       {geo.description}<br />
                       <em>{geo.category.replace('_', ' ')}</em>
                     </Popup>
-                  </Polygon>
-                ))}
+                  );
+                  return geo.type === 'circle' ? (
+                    <Circle
+                      key={geo.id}
+                      center={geo.center}
+                      radius={geo.radius_meters}
+                      pathOptions={{ color: getCategoryColor(geo.category) }}
+                    >
+                      {popup}
+                    </Circle>
+                  ) : (
+                    <Polygon
+                      key={geo.id}
+                      positions={geo.type === 'multipolygon' ? geo.polygons : [geo.coordinates, ...(geo.holes || [])]}
+                      pathOptions={{ color: getCategoryColor(geo.category) }}
+                    >
+                      {popup}
+                    </Polygon>
+                  );
+                })}
               </MapContainer>
             </div>
 
@@ -1,8 +1,20 @@
 import React, { useState, useEffect } from 'react';
 import { toast } from 'react-toastify';
-import { getViolationHistory, getGeofences, getVehicles } from '../services/api';
+import {
+  getViolationHistory,
+  getGeofences,
+  getVehicles,
+  acknowledgeViolation,
+  resolveViolation,
+} from '../services/api';
 
-const History = () => {
+const statusColors = {
+  open: '#f56565',
+  acknowledged: '#ed8936',
+  resolved: '#48bb78',
+};
+
+const History = ({ lastStatusChange }) => {
   const [violations, setViolations] = useState([]);
   const [totalCount, setTotalCount] = useState(0);
   const [loading, setLoading] = useState(true);
@@ -11,6 +23,7 @@ const History = () => {
   const [filters, setFilters] = useState({
     vehicle_id: '',
     geofence_id: '',
+    status: '',
     start_date: '',
     end_date: '',
     limit: 50,
@@ -20,6 +33,14 @@ const History = () => {
     loadData();
   }, []);
 
+  // Another dispatcher acknowledged or resolved a violation
+  useEffect(() => {
+    if (lastStatusChange) {
+      loadViolations();
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [lastStatusChange]);
+
   useEffect(() => {
     loadViolations();
     // eslint-disable-next-line react-hooks/exhaustive-deps
@@ -45,6 +66,7 @@ const History = () => {
       const params = {};
       if (filters.vehicle_id) params.vehicle_id = filters.vehicle_id;
       if (filters.geofence_id) params.geofence_id = filters.geofence_id;
+      if (filters.status) params.status = filters.status;
       if (filters.start_date) params.start_date = new Date(filters.start_date).toISOString();
       if (filters.end_date) params.end_date = new Date(filters.end_date).toISOString();
       params.limit = filters.limit;
@@ -59,10 +81,29 @@ const History = () => {
     }
   };
 
+  const changeStatus = async (violation, action) => {
+    const by = window.prompt('Your name');
+    if (!by) return;
+    const notes = window.prompt('Notes (optional)') || '';
+
+    try {
+      if (action === 'acknowledge') {
+        await acknowledgeViolation(violation.id, { by, notes });
+      } else {
+        await resolveViolation(violation.id, { by, notes });
+      }
+      toast.success(`Violation ${action === 'acknowledge' ? 'acknowledged' : 'resolved'}`);
+      loadViolations();
+    } catch (error) {
+      toast.error(error.response?.data?.message || error.response?.data || `Failed to ${action} violation`);
+    }
+  };
+
   const resetFilters = () => {
     setFilters({
       vehicle_id: '',
       geofence_id: '',
+      status: '',
       start_date: '',
       end_date: '',
       limit: 50,
@@ -103,6 +144,16 @@ const History = () => {
             ))}
           </select>
 
+          <select
+            value={filters.status}
+            onChange={(e) => setFilters({ ...filters, status: e.target.value })}
+          >
+            <option value="">All Statuses</option>
+            <option value="open">Open</option>
+            <option value="acknowledged">Acknowledged</option>
+            <option value="resolved">Resolved</option>
+          </select>
+
           <input
             type="date"
             value={filters.start_date}
@@ -153,6 +204,8 @@ const History = () => {
                 <th>Geofence</th>
                 <th>Event</th>
                 <th>Location</th>
+                <th>Status</th>
+                <th>Actions</th>
               </tr>
             </thead>
             <tbody>
@@ -175,6 +228,34 @@ const History = () => {
                   <td>
                     {violation.latitude.toFixed(4)}, {violation.longitude.toFixed(4)}
                   </td>
+                  <td>
+                    <span
+                      title={violation.resolved_by
+                        ? `Resolved by ${violation.resolved_by}`
+                        : violation.acknowledged_by ? `Acknowledged by ${violation.acknowledged_by}` : ''}
+                      style={{
+                        padding: '0.3rem 0.8rem',
+                        borderRadius: '12px',
+                        background: `${statusColors[violation.status]}20`,
+                        color: statusColors[violation.status],
+                        fontSize: '0.85rem',
+                      }}
+                    >
+                      {violation.status}
+                    </span>
+                  </td>
+                  <td>
+                    {violation.status === 'open' && (
+                      <button className="btn btn-secondary" onClick={() => changeStatus(violation, 'acknowledge')}>
+                        Acknowledge
+                      </button>
+                    )}
+                    {violation.status !== 'resolved' && (
+                      <button className="btn btn-primary" onClick={() => changeStatus(violation, 'resolve')}>
+                        Resolve
+                      </button>
+                    )}
+                  </td>
                 </tr>
               ))}
             </tbody>
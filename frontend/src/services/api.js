@@ -22,8 +22,14 @@ export const getVehicleLocation = (vehicleId) => api.get(`/vehicles/location/${v
 // Alert API
 export const configureAlert = (data) => api.post('/alerts/configure', data);
 export const getAlerts = (params) => api.get('/alerts', { params });
+export const updateAlert = (id, data) => api.patch(`/alerts/${id}`, data);
+export const pauseAlert = (id) => api.post(`/alerts/${id}/pause`);
+export const resumeAlert = (id) => api.post(`/alerts/${id}/resume`);
+export const deleteAlert = (id) => api.delete(`/alerts/${id}`);
 
 // Violation API
 export const getViolationHistory = (params) => api.get('/violations/history', { params });
+export const acknowledgeViolation = (id, data) => api.post(`/violations/${id}/acknowledge`, data);
+export const resolveViolation = (id, data) => api.post(`/violations/${id}/resolve`, data);
 
 export default api;